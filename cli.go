@@ -0,0 +1,694 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/TotallyNotLost/gotes/config"
+	"github.com/TotallyNotLost/gotes/diff"
+	"github.com/TotallyNotLost/gotes/markdown"
+	"github.com/TotallyNotLost/gotes/query"
+	"github.com/TotallyNotLost/gotes/search"
+	"github.com/TotallyNotLost/gotes/storage"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/charmbracelet/log"
+	"github.com/samber/lo"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	exitOK    = 0
+	exitError = 1
+	exitUsage = 2
+)
+
+// Files are taken from --file flags, falling back to $GOTES_FILES
+// and then the files in the config.
+const filesEnv = "GOTES_FILES"
+
+type command struct {
+	name        string
+	usage       string
+	description string
+	run         func(c *invocation) int
+}
+
+var commands []command
+
+func init() {
+	commands = []command{
+		{"tui", "tui FILES...", "Browse and edit notes interactively (default)", runTui},
+		{"add", "add FILE", "Add a note read from stdin to FILE", runAdd},
+		{"list", "list [--archived]", "List the latest revision of every note", runList},
+		{"show", "show ID [--revision N] [--html]", "Print a note", runShow},
+		{"diff", "diff ID [REV1] [REV2]", "Print the changes between two revisions of a note", runDiff},
+		{"search", "search QUERY [--revisions]", "Search the text of notes", runSearch},
+		{"query", "query QUERY | --view NAME", "List notes matching a query", runQuery},
+		{"archive", "archive ID", "Hide a note from lists", runArchive},
+		{"unarchive", "unarchive ID", "Show an archived note again", runUnarchive},
+		{"delete", "delete ID", "Delete a note, keeping its earlier revisions", runDelete},
+		{"compact", "compact [FILES...] --keep N | --since DATE [--archive] [--dry-run]", "Prune old revisions from notes files", runCompact},
+		{"verify", "verify", "Check that every identifier resolves", runVerify},
+	}
+}
+
+// invocation holds the parsed arguments of a command.
+type invocation struct {
+	flags  *flag.FlagSet
+	args   []string
+	files  []string
+	json   bool
+	config config.Config
+	out    io.Writer
+	in     io.Reader
+}
+
+type fileList []string
+
+func (f *fileList) String() string     { return strings.Join(*f, ",") }
+func (f *fileList) Set(v string) error { *f = append(*f, v); return nil }
+
+func run(args []string) int {
+	name := "tui"
+	if len(args) > 0 {
+		if _, ok := findCommand(args[0]); ok {
+			name, args = args[0], args[1:]
+		} else if args[0] == "help" || args[0] == "-h" || args[0] == "--help" {
+			usage(os.Stdout)
+			return exitOK
+		}
+	}
+
+	c, _ := findCommand(name)
+	inv, err := parse(c, args)
+	if errors.Is(err, flag.ErrHelp) {
+		return exitOK
+	}
+	if err != nil {
+		return exitUsage
+	}
+
+	return c.run(inv)
+}
+
+func findCommand(name string) (command, bool) {
+	return lo.Find(commands, func(c command) bool {
+		return c.name == name
+	})
+}
+
+func usage(w io.Writer) {
+	fmt.Fprintln(w, "Usage: gotes [COMMAND] [--file FILE]... [--json] [ARGS]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Commands:")
+	for _, c := range commands {
+		fmt.Fprintf(w, "  %-28s %s\n", c.usage, c.description)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintf(w, "Files may also be given as a %q separated list in $%s or in the config file.\n", string(filepath.ListSeparator), filesEnv)
+}
+
+func parse(c command, args []string) (*invocation, error) {
+	inv := &invocation{out: os.Stdout, in: os.Stdin}
+
+	cfg, err := config.Get()
+	if err != nil {
+		log.Warnf("Couldn't load config: %s", err)
+	}
+	inv.config = cfg
+	var files fileList
+
+	fs := flag.NewFlagSet(c.name, flag.ContinueOnError)
+	fs.Var(&files, "file", "notes file to load (repeatable)")
+	fs.Var(&files, "f", "shorthand for --file")
+	fs.BoolVar(&inv.json, "json", false, "print JSON")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: gotes %s\n", c.usage)
+		fs.PrintDefaults()
+	}
+	inv.flags = fs
+
+	// Command specific flags are registered before parsing.
+	switch c.name {
+	case "show":
+		fs.String("revision", "HEAD", "revision number (1 is the oldest) or HEAD~N")
+		fs.Bool("html", false, "print the note rendered as HTML")
+	case "search":
+		fs.Bool("revisions", false, "search older revisions too")
+	case "query":
+		fs.String("view", "", "name of a saved view to use as the query")
+		fs.Bool("archived", false, "include archived and deleted notes")
+	case "list":
+		fs.Bool("archived", false, "include archived and deleted notes")
+	case "compact":
+		fs.Int("keep", 0, "keep the latest N revisions of each note")
+		fs.String("since", "", "keep revisions updated after this date (2006-01-02 or RFC 3339)")
+		fs.Bool("archive", false, "move pruned revisions to a sidecar file, e.g. notes.archive.md")
+		fs.Bool("dry-run", false, "only report what would be pruned")
+	}
+
+	// Allow flags to be mixed with positional arguments.
+	takesQuery := c.name == "search" || c.name == "query"
+	for len(args) > 0 {
+		if takesQuery && isNegatedTerm(fs, args[0]) {
+			inv.args = append(inv.args, args[0])
+			args = args[1:]
+			continue
+		}
+		if args[0] == "--" {
+			inv.args = append(inv.args, args[1:]...)
+			break
+		}
+		// The flag package would take a negated term for an undefined flag,
+		// so only the flags before it are parsed.
+		flags, after := args, []string(nil)
+		if takesQuery {
+			if i := slices.IndexFunc(args, func(arg string) bool { return arg == "--" || isNegatedTerm(fs, arg) }); i >= 0 {
+				flags, after = args[:i], args[i:]
+			}
+		}
+		if err := fs.Parse(flags); err != nil {
+			return nil, err
+		}
+		rest := fs.Args()
+		if len(rest) > 0 && len(rest) < len(flags) && flags[len(flags)-len(rest)-1] == "--" {
+			inv.args = append(inv.args, rest...)
+			break
+		}
+		if len(rest) == 0 {
+			args = after
+			continue
+		}
+		inv.args = append(inv.args, rest[0])
+		args = slices.Concat(rest[1:], after)
+	}
+
+	inv.files = files
+	if len(inv.files) == 0 {
+		inv.files = filepath.SplitList(os.Getenv(filesEnv))
+	}
+	if len(inv.files) == 0 && len(inv.config.Files) > 0 {
+		inv.files = inv.config.Files
+	}
+
+	return inv, nil
+}
+
+// isNegatedTerm returns whether arg is a negated query term like -tag:#Done or -deploy
+// rather than a flag. Flags that aren't defined are taken as terms, except for -h and -help.
+func isNegatedTerm(fs *flag.FlagSet, arg string) bool {
+	name, found := strings.CutPrefix(arg, "-")
+	if !found || name == "" || strings.HasPrefix(name, "-") {
+		return false
+	}
+	name, _, _ = strings.Cut(name, "=")
+	name, _, _ = strings.Cut(name, ":")
+	return fs.Lookup(name) == nil && name != "h" && name != "help"
+}
+
+func (c *invocation) flag(name string) string {
+	return c.flags.Lookup(name).Value.String()
+}
+
+func (c *invocation) storage(extra ...string) (*storage.Storage, bool) {
+	files := lo.Uniq(append(extra, c.files...))
+	if len(files) == 0 {
+		log.Errorf("No notes files given. Use --file or $%s.", filesEnv)
+		return nil, false
+	}
+
+	store, err := storage.New(storage.NewCachedFileBackend(files, storage.DefaultCacheDir()))
+	if err != nil {
+		log.Error(err)
+		return nil, false
+	}
+
+	return store, true
+}
+
+func (c *invocation) printJSON(v any) int {
+	enc := json.NewEncoder(c.out)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		log.Error(err)
+		return exitError
+	}
+	return exitOK
+}
+
+func (c *invocation) printEntries(store *storage.Storage, entries []storage.Entry) int {
+	if c.json {
+		return c.printJSON(lo.Map(entries, func(e storage.Entry, index int) jsonEntry {
+			return toJSON(e, store.Title(e), false)
+		}))
+	}
+
+	for _, e := range entries {
+		fmt.Fprintf(c.out, "%s\t%s\t%s:%d\n", e.Id(), store.Title(e), e.File(), e.StartLine())
+	}
+
+	return exitOK
+}
+
+type jsonEntry struct {
+	Id      string     `json:"id"`
+	Title   string     `json:"title"`
+	File    string     `json:"file"`
+	Line    int        `json:"line"`
+	Related []string   `json:"related"`
+	Created *time.Time `json:"created,omitempty"`
+	Updated *time.Time `json:"updated,omitempty"`
+	Author  string     `json:"author,omitempty"`
+	Status  string     `json:"status,omitempty"`
+	Text    string     `json:"text,omitempty"`
+}
+
+// toJSON converts e, shown with title, for JSON output.
+func toJSON(e storage.Entry, title string, withText bool) jsonEntry {
+	j := jsonEntry{
+		Id:      e.Id(),
+		Title:   title,
+		File:    e.File(),
+		Line:    e.StartLine(),
+		Related: e.RelatedIds(),
+		Author:  e.Author(),
+		Status:  e.Status(),
+	}
+	if created, ok := e.Created(); ok {
+		j.Created = &created
+	}
+	if updated, ok := e.Updated(); ok {
+		j.Updated = &updated
+	}
+	if withText {
+		j.Text = e.Text()
+	}
+	return j
+}
+
+func runTui(c *invocation) int {
+	files := lo.Uniq(append(c.args, c.files...))
+	if len(files) == 0 {
+		usage(os.Stderr)
+		return exitUsage
+	}
+
+	return tui(files, c.config)
+}
+
+func runAdd(c *invocation) int {
+	if len(c.args) != 1 {
+		c.flags.Usage()
+		return exitUsage
+	}
+	file := c.args[0]
+
+	text, err := io.ReadAll(c.in)
+	if err != nil {
+		log.Error(err)
+		return exitError
+	}
+	if strings.TrimSpace(string(text)) == "" {
+		log.Error("Refusing to add an empty note")
+		return exitError
+	}
+
+	// Create the file so that it can be loaded.
+	f, err := os.OpenFile(file, os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		log.Error(err)
+		return exitError
+	}
+	f.Close()
+
+	store, ok := c.storage(file)
+	if !ok {
+		return exitError
+	}
+
+	revision := storage.NewRevision(file, strings.TrimRight(string(text), "\n"), config.Author(), time.Now())
+	entry, err := store.Write(revision)
+	if err != nil {
+		log.Error(err)
+		return exitError
+	}
+
+	if c.json {
+		return c.printJSON(toJSON(entry, store.Title(entry), true))
+	}
+
+	fmt.Fprintln(c.out, entry.Id())
+	return exitOK
+}
+
+func runList(c *invocation) int {
+	store, ok := c.storage()
+	if !ok {
+		return exitError
+	}
+
+	return c.printEntries(store, c.latestEntries(store))
+}
+
+// latestEntries returns the latest revision of every entry,
+// including archived and deleted ones if --archived was given.
+func (c *invocation) latestEntries(store *storage.Storage) []storage.Entry {
+	if archived, _ := strconv.ParseBool(c.flag("archived")); archived {
+		return store.GetAllLatestEntries()
+	}
+	return store.GetLatestEntries()
+}
+
+func runShow(c *invocation) int {
+	if len(c.args) != 1 {
+		c.flags.Usage()
+		return exitUsage
+	}
+
+	store, ok := c.storage()
+	if !ok {
+		return exitError
+	}
+
+	entry, ok := store.GetRevision(c.args[0], c.flag("revision"))
+	if !ok {
+		log.Errorf("Couldn't find revision %s of %s", c.flag("revision"), c.args[0])
+		return exitError
+	}
+
+	if c.json {
+		return c.printJSON(toJSON(entry, store.Title(entry), true))
+	}
+
+	if html, _ := strconv.ParseBool(c.flag("html")); html {
+		parser := markdown.NewParser(store)
+		parser.SetMaxDepth(config.MaxIncludeDepth())
+		rendered, err := parser.Parse(entry.Text()).HTML()
+		if err != nil {
+			log.Error(err)
+			return exitError
+		}
+		fmt.Fprint(c.out, rendered)
+		return exitOK
+	}
+
+	fmt.Fprintln(c.out, entry.Text())
+	return exitOK
+}
+
+// runDiff compares REV1 (HEAD~1 by default) with REV2 (HEAD by default).
+func runDiff(c *invocation) int {
+	if len(c.args) < 1 || len(c.args) > 3 {
+		c.flags.Usage()
+		return exitUsage
+	}
+
+	store, ok := c.storage()
+	if !ok {
+		return exitError
+	}
+
+	id, revs := c.args[0], []string{"HEAD~1", "HEAD"}
+	copy(revs, c.args[1:])
+
+	var texts, labels [2]string
+	for i, rev := range revs {
+		labels[i] = id + "@" + rev
+		entry, ok := store.GetRevision(id, rev)
+		if !ok && i == 0 && len(c.args) == 1 {
+			// The first revision is compared with an empty note.
+			if _, exists := store.GetLatest(id); exists {
+				labels[i] = "/dev/null"
+				continue
+			}
+		}
+		if !ok {
+			log.Errorf("Couldn't find revision %s of %s", rev, id)
+			return exitError
+		}
+		texts[i] = entry.Text()
+	}
+
+	fmt.Fprint(c.out, diff.Unified(texts[0], texts[1], labels[0], labels[1], 3))
+	return exitOK
+}
+
+type jsonResult struct {
+	jsonEntry
+	Score   float64 `json:"score"`
+	Snippet string  `json:"snippet"`
+}
+
+var highlightStyle = lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color("212"))
+
+func runSearch(c *invocation) int {
+	if len(c.args) == 0 {
+		c.flags.Usage()
+		return exitUsage
+	}
+
+	store, ok := c.storage()
+	if !ok {
+		return exitError
+	}
+
+	revisions, _ := strconv.ParseBool(c.flag("revisions"))
+	results := search.New(store, revisions).Search(strings.Join(c.args, " "))
+
+	if c.json {
+		code := c.printJSON(lo.Map(results, func(r search.Result, index int) jsonResult {
+			return jsonResult{
+				jsonEntry: toJSON(r.Entry, store.Title(r.Entry), false),
+				Score:     r.Score,
+				Snippet:   r.Snippet(80, func(s string) string { return s }),
+			}
+		}))
+		if code != exitOK {
+			return code
+		}
+	} else {
+		for _, r := range results {
+			e := r.Entry
+			snippet := r.Snippet(80, func(s string) string { return highlightStyle.Render(s) })
+			fmt.Fprintf(c.out, "%s\t%s\t%s:%d\t%s\n", e.Id(), store.Title(e), e.File(), e.StartLine(), snippet)
+		}
+	}
+
+	if len(results) == 0 {
+		return exitError
+	}
+	return exitOK
+}
+
+func runQuery(c *invocation) int {
+	source := strings.Join(c.args, " ")
+	if name := c.flag("view"); name != "" {
+		view, ok := lo.Find(c.config.Views, func(v config.View) bool {
+			return v.Name == name
+		})
+		if !ok {
+			log.Errorf("No saved view named %q", name)
+			return exitError
+		}
+		source = view.Query
+	}
+
+	q, err := query.Parse(source)
+	if err != nil {
+		log.Error(err)
+		return exitUsage
+	}
+
+	store, ok := c.storage()
+	if !ok {
+		return exitError
+	}
+
+	entries := q.Filter(c.latestEntries(store))
+	if code := c.printEntries(store, entries); code != exitOK {
+		return code
+	}
+	if len(entries) == 0 {
+		return exitError
+	}
+	return exitOK
+}
+
+func runArchive(c *invocation) int {
+	return c.writeRevision(func(e storage.Entry) (storage.Entry, error) {
+		if e.IsHidden() {
+			return storage.Entry{}, fmt.Errorf("%s is already %s", e.Id(), e.Status())
+		}
+		return storage.ArchiveRevision(e, config.Author(), time.Now()), nil
+	})
+}
+
+func runUnarchive(c *invocation) int {
+	return c.writeRevision(func(e storage.Entry) (storage.Entry, error) {
+		if !e.IsArchived() {
+			return storage.Entry{}, fmt.Errorf("%s isn't archived", e.Id())
+		}
+		return storage.UnarchiveRevision(e, config.Author(), time.Now()), nil
+	})
+}
+
+func runDelete(c *invocation) int {
+	return c.writeRevision(func(e storage.Entry) (storage.Entry, error) {
+		if e.IsDeleted() {
+			return storage.Entry{}, fmt.Errorf("%s is already deleted", e.Id())
+		}
+		return storage.DeleteRevision(e, config.Author(), time.Now()), nil
+	})
+}
+
+// writeRevision writes the revision created by revise for the latest revision of the entry given as the only argument.
+func (c *invocation) writeRevision(revise func(storage.Entry) (storage.Entry, error)) int {
+	if len(c.args) != 1 {
+		c.flags.Usage()
+		return exitUsage
+	}
+
+	store, ok := c.storage()
+	if !ok {
+		return exitError
+	}
+
+	latest, ok := store.GetLatest(c.args[0])
+	if !ok {
+		log.Errorf("No note with id %s", c.args[0])
+		return exitError
+	}
+
+	revision, err := revise(latest)
+	if err != nil {
+		log.Error(err)
+		return exitError
+	}
+
+	entry, err := store.Write(revision)
+	if err != nil {
+		log.Error(err)
+		return exitError
+	}
+
+	if c.json {
+		return c.printJSON(toJSON(entry, store.Title(entry), false))
+	}
+
+	fmt.Fprintln(c.out, entry.Id())
+	return exitOK
+}
+
+type jsonCompactReport struct {
+	File          string      `json:"file"`
+	Kept          int         `json:"kept"`
+	Pruned        []jsonEntry `json:"pruned"`
+	Size          int64       `json:"size"`
+	CompactedSize int64       `json:"compactedSize"`
+}
+
+func runCompact(c *invocation) int {
+	given := map[string]bool{}
+	c.flags.Visit(func(f *flag.Flag) { given[f.Name] = true })
+	if !given["keep"] && !given["since"] {
+		log.Error("Either --keep or --since is required")
+		c.flags.Usage()
+		return exitUsage
+	}
+
+	files := lo.Uniq(append(c.args, c.files...))
+	if len(files) == 0 {
+		log.Errorf("No notes files given. Use --file or $%s.", filesEnv)
+		return exitUsage
+	}
+
+	var opts storage.CompactOptions
+	opts.Keep, _ = strconv.Atoi(c.flag("keep"))
+	opts.DryRun, _ = strconv.ParseBool(c.flag("dry-run"))
+	if since := c.flag("since"); since != "" {
+		var err error
+		if opts.Since, err = parseDate(since); err != nil {
+			log.Error(err)
+			return exitUsage
+		}
+	}
+	archive, _ := strconv.ParseBool(c.flag("archive"))
+
+	reports := []jsonCompactReport{}
+	for _, file := range files {
+		if archive {
+			opts.Archive = storage.ArchivePath(file)
+		}
+
+		report, err := storage.Compact(file, opts)
+		if err != nil {
+			log.Error(err)
+			return exitError
+		}
+
+		if c.json {
+			reports = append(reports, jsonCompactReport{
+				File:          report.File,
+				Kept:          report.Kept,
+				Pruned:        lo.Map(report.Pruned, func(e storage.Entry, index int) jsonEntry { return toJSON(e, e.Title(), false) }),
+				Size:          report.Size,
+				CompactedSize: report.CompactedSize,
+			})
+			continue
+		}
+
+		verb := "Pruned"
+		if opts.DryRun {
+			verb = "Would prune"
+			for _, e := range report.Pruned {
+				fmt.Fprintf(c.out, "%s\t%s\t%s:%d\n", e.Id(), e.Title(), e.File(), e.StartLine())
+			}
+		}
+		fmt.Fprintf(c.out, "%s: %s %d of %d revisions, %d -> %d bytes\n",
+			file, verb, len(report.Pruned), report.Kept+len(report.Pruned), report.Size, report.CompactedSize)
+	}
+
+	if c.json {
+		return c.printJSON(reports)
+	}
+	return exitOK
+}
+
+func parseDate(s string) (time.Time, error) {
+	for _, layout := range []string{time.RFC3339, "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid date %q", s)
+}
+
+func runVerify(c *invocation) int {
+	store, ok := c.storage()
+	if !ok {
+		return exitError
+	}
+
+	if c.json {
+		problems := findProblems(store)
+		if code := c.printJSON(problems); code != exitOK {
+			return code
+		}
+		if hasErrors(problems) {
+			return exitError
+		}
+		return exitOK
+	}
+
+	if !verify(store) {
+		return exitError
+	}
+	return exitOK
+}
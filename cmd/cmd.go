@@ -1,8 +1,16 @@
 package cmd
 
 import (
+	"errors"
+	"fmt"
+	"github.com/TotallyNotLost/gotes/config"
 	"github.com/TotallyNotLost/gotes/storage"
 	tea "github.com/charmbracelet/bubbletea"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
 )
 
 func Back() tea.Msg {
@@ -11,10 +19,41 @@ func Back() tea.Msg {
 
 type BackMsg int
 
+// NewEntry creates a new revision of an entry, stamped with the time and author.
 func NewEntry(file string, text string) tea.Cmd {
 	return func() tea.Msg {
 		return NewEntryMsg{
-			entry: storage.NewEntry(file, text, 0, 0, 0),
+			entry: storage.NewRevision(file, text, config.Author(), time.Now()),
+		}
+	}
+}
+
+// RevertEntry creates a new revision of an entry with the text of an earlier revision.
+// number is the revision's number, 1 being the oldest, and is recorded as reverted-from.
+func RevertEntry(revision storage.Entry, number int) tea.Cmd {
+	text := storage.SetMetadata(revision.Text(), "reverted-from", strconv.Itoa(number))
+	return NewEntry(revision.File(), text)
+}
+
+// ArchiveEntry creates a revision of an entry that hides it from lists.
+func ArchiveEntry(entry storage.Entry) tea.Cmd {
+	return newRevision(entry, storage.ArchiveRevision)
+}
+
+// UnarchiveEntry creates a revision of an archived entry that shows it again.
+func UnarchiveEntry(entry storage.Entry) tea.Cmd {
+	return newRevision(entry, storage.UnarchiveRevision)
+}
+
+// DeleteEntry creates a tombstone revision of an entry.
+func DeleteEntry(entry storage.Entry) tea.Cmd {
+	return newRevision(entry, storage.DeleteRevision)
+}
+
+func newRevision(entry storage.Entry, revise func(storage.Entry, string, time.Time) storage.Entry) tea.Cmd {
+	return func() tea.Msg {
+		return NewEntryMsg{
+			entry: revise(entry, config.Author(), time.Now()),
 		}
 	}
 }
@@ -43,6 +82,74 @@ func (msg EditEntryMsg) GetEntry() storage.Entry {
 	return msg.entry
 }
 
+// EditEntryExternally suspends the program and edits the entry in $VISUAL or $EDITOR.
+// A new revision is only created if the text was changed. The entry's id is kept
+// even if its metadata line was removed.
+func EditEntryExternally(entry storage.Entry) tea.Cmd {
+	f, err := os.CreateTemp("", "gotes-*.md")
+	if err != nil {
+		return Error(err)
+	}
+	defer f.Close()
+
+	if _, err := f.WriteString(entry.Text() + "\n"); err != nil {
+		os.Remove(f.Name())
+		return Error(err)
+	}
+
+	args := strings.Fields(externalEditor())
+	c := exec.Command(args[0], append(args[1:], f.Name())...)
+
+	return tea.ExecProcess(c, func(err error) tea.Msg {
+		defer os.Remove(f.Name())
+		if err != nil {
+			return ErrorMsg{err: fmt.Errorf("running %s: %w", args[0], err)}
+		}
+
+		b, err := os.ReadFile(f.Name())
+		if err != nil {
+			return ErrorMsg{err: err}
+		}
+
+		text := strings.TrimRight(string(b), "\n")
+		if text == entry.Text() {
+			return nil
+		}
+		if strings.TrimSpace(text) == "" {
+			return ErrorMsg{err: errors.New("Not saving an empty entry")}
+		}
+		if entry.Id() != "" && len(storage.GetMetadata(text)["id"]) == 0 {
+			text = storage.SetMetadata(text, "id", entry.Id())
+		}
+
+		return NewEntry(entry.File(), text)()
+	})
+}
+
+// externalEditor returns the command used to edit entries outside of gotes.
+func externalEditor() string {
+	for _, env := range []string{"VISUAL", "EDITOR"} {
+		if e := strings.TrimSpace(os.Getenv(env)); e != "" {
+			return e
+		}
+	}
+	return "vi"
+}
+
+func Error(err error) tea.Cmd {
+	return func() tea.Msg {
+		return ErrorMsg{err: err}
+	}
+}
+
+type ErrorMsg struct {
+	err error
+}
+
+func (msg ErrorMsg) GetError() error {
+	return msg.err
+}
+
 func ViewEntry(entry storage.Entry) tea.Cmd {
 	return func() tea.Msg {
 		return ViewEntryMsg{
@@ -58,3 +165,22 @@ type ViewEntryMsg struct {
 func (msg ViewEntryMsg) GetEntry() storage.Entry {
 	return msg.entry
 }
+
+// WaitForChanges waits for the next batch of changed files.
+func WaitForChanges(changes <-chan []string) tea.Cmd {
+	return func() tea.Msg {
+		files, ok := <-changes
+		if !ok {
+			return nil
+		}
+		return FilesChangedMsg{files: files}
+	}
+}
+
+type FilesChangedMsg struct {
+	files []string
+}
+
+func (msg FilesChangedMsg) GetFiles() []string {
+	return msg.files
+}
@@ -0,0 +1,113 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Config is read from $XDG_CONFIG_HOME/gotes/config.json
+// (or the platform equivalent), or $GOTES_CONFIG if set.
+type Config struct {
+	// Notes files to load when none are given on the command line.
+	Files []string `json:"files"`
+	// Saved views. The first one is used when the TUI starts.
+	Views []View `json:"views"`
+	// Recorded as the author of new revisions. Defaults to $USER.
+	Author string `json:"author"`
+	// Edit entries in $VISUAL or $EDITOR instead of the built-in editor.
+	ExternalEditor bool `json:"externalEditor"`
+	// Use vim-style modal editing in the built-in editor.
+	Vim bool `json:"vim"`
+	// How deeply includes are expanded within included text. 0 uses the default.
+	MaxIncludeDepth int `json:"maxIncludeDepth"`
+}
+
+// View is a named query.
+type View struct {
+	Name  string `json:"name"`
+	Query string `json:"query"`
+}
+
+var DefaultView = View{Name: "Open", Query: "-tag:#Done"}
+
+func defaults() Config {
+	return Config{
+		Views: []View{DefaultView},
+	}
+}
+
+// Path returns the location of the config file.
+func Path() (string, error) {
+	if p := os.Getenv("GOTES_CONFIG"); p != "" {
+		return p, nil
+	}
+
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(dir, "gotes", "config.json"), nil
+}
+
+// Load reads the config file. A missing file isn't an error.
+func Load() (Config, error) {
+	c := defaults()
+
+	path, err := Path()
+	if err != nil {
+		return c, err
+	}
+
+	b, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return c, nil
+	}
+	if err != nil {
+		return c, err
+	}
+
+	if err := json.Unmarshal(b, &c); err != nil {
+		return defaults(), err
+	}
+
+	if len(c.Views) == 0 {
+		c.Views = []View{DefaultView}
+	}
+
+	return c, nil
+}
+
+// Author returns who new revisions are attributed to.
+func Author() string {
+	c, _ := Get()
+	if c.Author != "" {
+		return c.Author
+	}
+	return os.Getenv("USER")
+}
+
+// MaxIncludeDepth returns how deeply includes are expanded within included text,
+// or 0 to use the default.
+func MaxIncludeDepth() int {
+	c, _ := Get()
+	return c.MaxIncludeDepth
+}
+
+var (
+	once    sync.Once
+	current Config
+	loadErr error
+)
+
+// Get returns the config, loading it the first time it's called.
+func Get() (Config, error) {
+	once.Do(func() {
+		current, loadErr = Load()
+	})
+	return current, loadErr
+}
@@ -0,0 +1,243 @@
+package diff
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+type Kind int
+
+const (
+	Equal Kind = iota
+	Insert
+	Delete
+)
+
+// Op is a run of text that's unchanged, inserted or deleted.
+type Op struct {
+	Kind Kind
+	Text string
+}
+
+// diff returns the operations that turn a into b, using the longest common subsequence.
+func diff(a []string, b []string) []Op {
+	// Trim the common prefix and suffix to keep the table small.
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	ops := make([]Op, 0, len(a)+len(b))
+	for _, s := range a[:prefix] {
+		ops = append(ops, Op{Equal, s})
+	}
+
+	ma, mb := a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]
+
+	// lcs[i][j] is the length of the LCS of ma[i:] and mb[j:].
+	lcs := make([][]int, len(ma)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(mb)+1)
+	}
+	for i := len(ma) - 1; i >= 0; i-- {
+		for j := len(mb) - 1; j >= 0; j-- {
+			if ma[i] == mb[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	i, j := 0, 0
+	for i < len(ma) || j < len(mb) {
+		switch {
+		case i < len(ma) && j < len(mb) && ma[i] == mb[j]:
+			ops = append(ops, Op{Equal, ma[i]})
+			i++
+			j++
+		case j < len(mb) && (i == len(ma) || lcs[i][j+1] > lcs[i+1][j]):
+			ops = append(ops, Op{Insert, mb[j]})
+			j++
+		default:
+			ops = append(ops, Op{Delete, ma[i]})
+			i++
+		}
+	}
+
+	for _, s := range a[len(a)-suffix:] {
+		ops = append(ops, Op{Equal, s})
+	}
+
+	return ops
+}
+
+// Lines diffs a and b line by line. Each op is a single line without its newline.
+// An empty string has no lines, so a new note is all inserts.
+func Lines(a string, b string) []Op {
+	return diff(lines(a), lines(b))
+}
+
+func lines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, "\n")
+}
+
+var word = regexp.MustCompile(`\w+|\s+|.`)
+
+// Words diffs a and b word by word. Whitespace and punctuation are separate words.
+func Words(a string, b string) []Op {
+	ops := diff(word.FindAllString(a, -1), word.FindAllString(b, -1))
+
+	// Merge consecutive ops of the same kind.
+	merged := []Op{}
+	for _, op := range ops {
+		if n := len(merged); n > 0 && merged[n-1].Kind == op.Kind {
+			merged[n-1].Text += op.Text
+			continue
+		}
+		merged = append(merged, op)
+	}
+
+	return merged
+}
+
+// Unified returns a unified diff of a and b with context lines around each change.
+// It's empty when a and b are the same.
+func Unified(a string, b string, fromName string, toName string, context int) string {
+	ops := Lines(a, b)
+	if !slices.ContainsFunc(ops, func(op Op) bool { return op.Kind != Equal }) {
+		return ""
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "--- %s\n+++ %s\n", fromName, toName)
+
+	// Line numbers in a and b of each op.
+	aLines, bLines := make([]int, len(ops)), make([]int, len(ops))
+	ai, bi := 1, 1
+	for k, op := range ops {
+		aLines[k], bLines[k] = ai, bi
+		if op.Kind != Insert {
+			ai++
+		}
+		if op.Kind != Delete {
+			bi++
+		}
+	}
+
+	for k := 0; k < len(ops); {
+		if ops[k].Kind == Equal {
+			k++
+			continue
+		}
+
+		// Extend the hunk while changes are within 2*context lines of each other.
+		start := max(0, k-context)
+		end := k
+		for end < len(ops) {
+			if ops[end].Kind != Equal {
+				end++
+				continue
+			}
+			next := end
+			for next < len(ops) && ops[next].Kind == Equal {
+				next++
+			}
+			if next == len(ops) || next-end > 2*context {
+				end = min(len(ops), end+context)
+				break
+			}
+			end = next
+		}
+
+		aCount, bCount := 0, 0
+		for _, op := range ops[start:end] {
+			if op.Kind != Insert {
+				aCount++
+			}
+			if op.Kind != Delete {
+				bCount++
+			}
+		}
+		fmt.Fprintf(&sb, "@@ -%s +%s @@\n", hunkRange(aLines[start], aCount), hunkRange(bLines[start], bCount))
+
+		for _, op := range ops[start:end] {
+			sb.WriteString(prefix(op.Kind) + op.Text + "\n")
+		}
+
+		k = end
+	}
+
+	return sb.String()
+}
+
+func hunkRange(start int, count int) string {
+	if count == 0 {
+		start--
+	}
+	if count == 1 {
+		return fmt.Sprint(start)
+	}
+	return fmt.Sprintf("%d,%d", start, count)
+}
+
+func prefix(k Kind) string {
+	switch k {
+	case Insert:
+		return "+"
+	case Delete:
+		return "-"
+	}
+	return " "
+}
+
+// MetadataChange is a metadata value that was added or removed.
+type MetadataChange struct {
+	Kind  Kind
+	Key   string
+	Value string
+}
+
+// Metadata compares the metadata of two revisions.
+func Metadata(a map[string][]string, b map[string][]string) []MetadataChange {
+	var changes []MetadataChange
+
+	keys := []string{}
+	for k := range a {
+		keys = append(keys, k)
+	}
+	for k := range b {
+		if _, ok := a[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	slices.Sort(keys)
+
+	for _, k := range keys {
+		for _, v := range a[k] {
+			if !slices.Contains(b[k], v) {
+				changes = append(changes, MetadataChange{Delete, k, v})
+			}
+		}
+		for _, v := range b[k] {
+			if !slices.Contains(a[k], v) {
+				changes = append(changes, MetadataChange{Insert, k, v})
+			}
+		}
+	}
+
+	return changes
+}
+
+func (c MetadataChange) String() string {
+	return fmt.Sprintf("%s%s: %s", prefix(c.Kind), c.Key, c.Value)
+}
@@ -0,0 +1,257 @@
+package editor
+
+import (
+	"fmt"
+	"github.com/TotallyNotLost/gotes/storage"
+	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/sahilm/fuzzy"
+	"github.com/samber/lo"
+	"slices"
+	"strings"
+)
+
+const maxCompletions = 5
+
+var (
+	popupStyle        = lipgloss.NewStyle().PaddingLeft(2)
+	completionStyle   = lipgloss.NewStyle().Foreground(lipgloss.Color("241"))
+	selectedStyle     = lipgloss.NewStyle().Foreground(lipgloss.Color("212")).Bold(true)
+	completionIdStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("238"))
+)
+
+// trigger is text that starts completing an entry.
+// The id of the chosen entry replaces what was typed after open, followed by close.
+type trigger struct {
+	open  string
+	close string
+	// Whether the query can contain spaces, to type part of a title.
+	spaces bool
+	// Whether open has to start a word.
+	word bool
+	// Whether the title of the chosen entry is inserted instead of its id.
+	title bool
+}
+
+var triggers = []trigger{
+	{open: "{$", close: "}"},
+	{open: "id=", close: "", word: true},
+	// Link and include metadata.
+	{open: "\"$", close: ""},
+	// Wiki links by title.
+	{open: "[[", close: "]]", spaces: true, title: true},
+}
+
+type completion struct {
+	trigger trigger
+	// Index in the text where the trigger ends. The query runs from here to the cursor.
+	start    int
+	query    string
+	matches  []storage.Entry
+	selected int
+}
+
+// entrySource lets entries be fuzzy matched by title.
+type entrySource []storage.Entry
+
+func (s entrySource) String(i int) string { return s[i].Title() }
+func (s entrySource) Len() int            { return len(s) }
+
+// match returns the entries whose titles best match query, other than the one being edited.
+func (m Model) match(query string, limit int) []storage.Entry {
+	if m.storage == nil {
+		return nil
+	}
+	entries := lo.Filter(m.storage.GetLatestEntries(), func(e storage.Entry, index int) bool {
+		return e.Id() != m.entry.Id()
+	})
+
+	if query != "" {
+		entries = lo.Map(fuzzy.FindFrom(query, entrySource(entries)), func(match fuzzy.Match, index int) storage.Entry {
+			return entries[match.Index]
+		})
+	}
+
+	if limit > 0 && len(entries) > limit {
+		entries = entries[:limit]
+	}
+	return entries
+}
+
+// complete returns the completion for the text before the cursor, if it ends in a trigger
+// followed by a query.
+func (m Model) complete() *completion {
+	b := read(&m.textarea)
+	lineStart := b.lineStart(b.cursor)
+	line := string(b.text[lineStart:b.cursor])
+
+	var c *completion
+	best := -1
+	for _, t := range triggers {
+		i := strings.LastIndex(line, t.open)
+		if i <= best {
+			continue
+		}
+		if t.word && i > 0 && b.class(lineStart+len([]rune(line[:i]))-1) == 1 {
+			continue
+		}
+		query := line[i+len(t.open):]
+		stop := " \"}]"
+		if t.spaces {
+			stop = "]"
+		}
+		if strings.ContainsAny(query, stop) {
+			continue
+		}
+		best = i
+		c = &completion{
+			trigger: t,
+			start:   lineStart + len([]rune(line[:i+len(t.open)])),
+			query:   query,
+		}
+	}
+
+	if c == nil || c.start == m.dismissed {
+		return nil
+	}
+
+	c.matches = m.match(c.query, maxCompletions)
+	if len(c.matches) == 0 {
+		return nil
+	}
+	if m.completion != nil && m.completion.start == c.start {
+		c.selected = min(m.completion.selected, len(c.matches)-1)
+	}
+	return c
+}
+
+// updateCompletion handles the keys that choose a completion. handled is false for other keys.
+func (m *Model) updateCompletion(msg tea.KeyMsg) (handled bool) {
+	c := m.completion
+	switch {
+	case key.Matches(msg, m.keyMap.CompletionUp):
+		c.selected = (c.selected + len(c.matches) - 1) % len(c.matches)
+	case key.Matches(msg, m.keyMap.CompletionDown):
+		c.selected = (c.selected + 1) % len(c.matches)
+	case key.Matches(msg, m.keyMap.Accept):
+		t := c.trigger
+		chosen := c.matches[c.selected].Id()
+		if t.title {
+			chosen = strings.TrimLeft(strings.TrimSpace(c.matches[c.selected].Title()), "# ")
+		}
+		value := chosen + t.close
+		start := c.start
+
+		b := read(&m.textarea)
+		b.text = slices.Replace(b.text, start, b.cursor, []rune(value)...)
+		b.cursor = start + len([]rune(value))
+		b.write(&m.textarea)
+		m.completion = nil
+	case key.Matches(msg, m.keyMap.Dismiss):
+		m.dismissed = c.start
+		m.completion = nil
+	default:
+		return false
+	}
+	return true
+}
+
+func (c completion) view() string {
+	lines := lo.Map(c.matches, func(e storage.Entry, i int) string {
+		style := completionStyle
+		prefix := "  "
+		if i == c.selected {
+			style, prefix = selectedStyle, "› "
+		}
+		return style.Render(prefix+e.Title()) + " " + completionIdStyle.Render(e.Id())
+	})
+	return popupStyle.Render(strings.Join(lines, "\n"))
+}
+
+// picker adds and removes related ids of the entry being edited.
+type picker struct {
+	input    textinput.Model
+	matches  []storage.Entry
+	selected int
+}
+
+func newPicker() *picker {
+	input := textinput.New()
+	input.Prompt = "Related: "
+	input.Placeholder = "filter by title"
+	input.Focus()
+	return &picker{input: input}
+}
+
+func (m *Model) openPicker() tea.Cmd {
+	m.picker = newPicker()
+	m.picker.matches = m.match("", 0)
+	return textinput.Blink
+}
+
+func (m *Model) updatePicker(msg tea.KeyMsg) tea.Cmd {
+	p := m.picker
+	switch {
+	case key.Matches(msg, m.keyMap.Dismiss, m.keyMap.Related):
+		m.picker = nil
+		return nil
+	case key.Matches(msg, m.keyMap.CompletionUp):
+		p.selected = max(p.selected-1, 0)
+		return nil
+	case key.Matches(msg, m.keyMap.CompletionDown):
+		p.selected = min(p.selected+1, len(p.matches)-1)
+		return nil
+	case key.Matches(msg, m.keyMap.Toggle):
+		if p.selected < len(p.matches) {
+			m.toggleRelated(p.matches[p.selected].Id())
+		}
+		return nil
+	}
+
+	var cmd tea.Cmd
+	p.input, cmd = p.input.Update(msg)
+	p.matches = m.match(p.input.Value(), 0)
+	p.selected = max(0, min(p.selected, len(p.matches)-1))
+	return cmd
+}
+
+// toggleRelated adds id to the related metadata of the text being edited, or removes it.
+func (m *Model) toggleRelated(id string) {
+	b := read(&m.textarea)
+	text := string(b.text)
+	related := "id=" + id
+
+	if slices.Contains(storage.GetMetadata(text)["related"], related) {
+		text = storage.RemoveMetadataValue(text, "related", related)
+	} else {
+		// Drop the empty placeholder that new entries start with.
+		text = storage.RemoveMetadataValue(text, "related", "")
+		text = storage.AddMetadata(text, "related", related)
+	}
+
+	b.text = []rune(text)
+	b.cursor = min(b.cursor, len(b.text))
+	b.write(&m.textarea)
+}
+
+func (p picker) view(related []string, height int) string {
+	// Keep the selected entry visible.
+	first := max(0, p.selected-height+1)
+	shown := p.matches[first:min(len(p.matches), first+height)]
+
+	lines := lo.Map(shown, func(e storage.Entry, i int) string {
+		check := "[ ]"
+		if slices.Contains(related, e.Id()) {
+			check = "[x]"
+		}
+		style := completionStyle
+		if first+i == p.selected {
+			style = selectedStyle
+		}
+		return style.Render(fmt.Sprintf("%s %s", check, e.Title())) + " " + completionIdStyle.Render(e.Id())
+	})
+
+	return popupStyle.Render(lipgloss.JoinVertical(lipgloss.Left, append([]string{p.input.View()}, lines...)...))
+}
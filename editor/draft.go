@@ -0,0 +1,196 @@
+package editor
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	tea "github.com/charmbracelet/bubbletea"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// How often unsaved changes are written to a draft.
+const autosaveInterval = 5 * time.Second
+
+// draft is unsaved text of an entry, kept in case gotes exits before it's submitted.
+type draft struct {
+	File string    `json:"file"`
+	Id   string    `json:"id"`
+	Text string    `json:"text"`
+	New  bool      `json:"new"`
+	Time time.Time `json:"time"`
+}
+
+type autosaveMsg struct {
+	// Ticks scheduled while editing an earlier entry are ignored.
+	seq int
+}
+
+// SetDraftDir sets where drafts are saved. Drafts are disabled when dir is empty.
+func (m *Model) SetDraftDir(dir string) {
+	m.draftDir = dir
+}
+
+func (m Model) draftPath(file string, id string) string {
+	abs, err := filepath.Abs(file)
+	if err != nil {
+		abs = file
+	}
+	h := sha256.Sum256([]byte(abs + "\x00" + id))
+	return filepath.Join(m.draftDir, hex.EncodeToString(h[:8])+".json")
+}
+
+// isNew returns whether the entry being edited hasn't been saved yet.
+func (m Model) isNew() bool {
+	if m.storage == nil {
+		return false
+	}
+	_, ok := m.storage.GetLatest(m.entry.Id())
+	return !ok
+}
+
+// scheduleAutosave saves a draft after a while if there are unsaved changes.
+func (m *Model) scheduleAutosave() tea.Cmd {
+	if m.draftDir == "" || m.autosaving || !m.dirty() {
+		return nil
+	}
+	m.autosaving = true
+	msg := autosaveMsg{seq: m.autosaveSeq}
+	return tea.Tick(autosaveInterval, func(time.Time) tea.Msg {
+		return msg
+	})
+}
+
+func (m *Model) autosave() error {
+	m.autosaving = false
+	if m.draftDir == "" || !m.dirty() {
+		return nil
+	}
+
+	d := draft{
+		File: m.entry.File(),
+		Id:   m.entry.Id(),
+		Text: m.textarea.Value(),
+		New:  m.isNew(),
+		Time: time.Now(),
+	}
+	b, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(m.draftDir, 0700); err != nil {
+		return err
+	}
+	path := m.draftPath(d.File, d.Id)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// RemoveDraft removes the draft of the entry being edited. Call it once the entry was saved.
+func (m *Model) RemoveDraft() {
+	if m.draftDir != "" {
+		os.Remove(m.draftPath(m.entry.File(), m.entry.Id()))
+		if m.recovered != "" {
+			os.Remove(m.recovered)
+			m.recovered = ""
+		}
+	}
+}
+
+// findDraft returns the draft of the entry being edited. For new entries
+// it's the latest draft of any new entry in the same file.
+func (m Model) findDraft() (draft, string, bool) {
+	if m.draftDir == "" {
+		return draft{}, "", false
+	}
+
+	path := m.draftPath(m.entry.File(), m.entry.Id())
+	if d, ok := readDraft(path); ok && d.Text != m.entry.String() {
+		return d, path, true
+	}
+
+	if !m.isNew() {
+		return draft{}, "", false
+	}
+
+	paths, _ := filepath.Glob(filepath.Join(m.draftDir, "*.json"))
+	var drafts []draft
+	byDraft := make(map[time.Time]string)
+	for _, p := range paths {
+		if d, ok := readDraft(p); ok && d.New && d.File == m.entry.File() {
+			if _, saved := m.storage.GetLatest(d.Id); !saved {
+				drafts = append(drafts, d)
+				byDraft[d.Time] = p
+			}
+		}
+	}
+	if len(drafts) == 0 {
+		return draft{}, "", false
+	}
+	sort.Slice(drafts, func(i, j int) bool { return drafts[i].Time.After(drafts[j].Time) })
+	return drafts[0], byDraft[drafts[0].Time], true
+}
+
+func readDraft(path string) (draft, bool) {
+	var d draft
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return d, false
+	}
+	return d, json.Unmarshal(b, &d) == nil
+}
+
+// offerDraft asks whether to recover the draft of the entry being edited, if there is one.
+func (m *Model) offerDraft() {
+	d, path, ok := m.findDraft()
+	if !ok {
+		return
+	}
+
+	m.confirm = &confirmation{
+		question: fmt.Sprintf("Recover the unsaved draft from %s?", d.Time.Local().Format("2006-01-02 15:04")),
+		yes: func(m *Model) tea.Cmd {
+			m.textarea.SetValue(d.Text)
+			m.recovered = path
+			m.renderPreview()
+			return nil
+		},
+		no: func(m *Model) tea.Cmd {
+			os.Remove(path)
+			return nil
+		},
+	}
+}
+
+// confirmation is a yes or no question shown above the help.
+type confirmation struct {
+	question string
+	yes      func(m *Model) tea.Cmd
+	no       func(m *Model) tea.Cmd
+}
+
+func (m *Model) updateConfirmation(msg tea.KeyMsg) tea.Cmd {
+	c := m.confirm
+	switch msg.String() {
+	case "y", "Y":
+		m.confirm = nil
+		return c.yes(m)
+	case "n", "N", "esc":
+		m.confirm = nil
+		if c.no != nil {
+			return c.no(m)
+		}
+	}
+	return nil
+}
+
+func (c confirmation) view() string {
+	return c.question + " (y/n)"
+}
@@ -8,6 +8,8 @@ import (
 	"github.com/charmbracelet/bubbles/textarea"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
+	"github.com/samber/lo"
+	"strings"
 )
 
 const gap = "\n\n"
@@ -32,9 +34,11 @@ var (
 	focusedInputStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("205"))
 
 	helpStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("241")).PaddingLeft(2).Render
+
+	warningStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("214")).PaddingLeft(2).Render
 )
 
-func New() Model {
+func New(storage *storage.Storage) Model {
 	ta := textarea.New()
 	ta.MaxHeight = 0
 	ta.CharLimit = 0
@@ -49,9 +53,11 @@ func New() Model {
 	ta.Focus()
 
 	return Model{
-		textarea: ta,
-		keyMap:   defaultKeyMap(),
-		help:     help.New(),
+		textarea:  ta,
+		keyMap:    defaultKeyMap(),
+		help:      help.New(),
+		storage:   storage,
+		dismissed: -1,
 	}
 }
 
@@ -60,19 +66,82 @@ type Model struct {
 	textarea textarea.Model
 	keyMap   keyMap
 	help     help.Model
+	warning  string
+	// Modal editing, when enabled.
+	vim *vim
+	// Used to complete ids and pick related entries.
+	storage    *storage.Storage
+	completion *completion
+	// Where the completion that was dismissed with esc started.
+	dismissed int
+	picker    *picker
+	// Shown next to the textarea when enabled.
+	preview *preview
+	// Where drafts of unsaved changes are kept, if anywhere.
+	draftDir    string
+	autosaving  bool
+	autosaveSeq int
+	// The draft that was recovered, removed once the entry is submitted or discarded.
+	recovered string
+	// A question that has to be answered before anything else.
+	confirm *confirmation
+	height  int
+	width   int
 }
 
 func (m *Model) SetEntry(entry storage.Entry) {
 	m.entry = entry
+	m.warning = ""
 	m.textarea.SetValue(entry.String())
+	if m.vim != nil {
+		m.vim.reset(&m.textarea)
+	}
+	m.completion, m.picker, m.dismissed = nil, nil, -1
+	m.confirm, m.recovered = nil, ""
+	// A pending autosave may never be delivered if the last edit ended before it fired.
+	m.autosaving = false
+	m.autosaveSeq++
+	m.resize()
+	m.renderPreview()
+	m.offerDraft()
+}
+
+// SetVim enables or disables vim-style modal editing.
+func (m *Model) SetVim(enabled bool) {
+	m.vim = nil
+	if enabled {
+		m.vim = newVim()
+	}
+}
+
+// SetWarning shows warning above the help until another entry is edited.
+func (m *Model) SetWarning(warning string) {
+	m.warning = warning
 }
 
 func (m *Model) SetHeight(height int) {
-	m.textarea.SetHeight(height - 4)
+	m.height = height
+	m.resize()
+}
+
+// resize makes room for the completions or picker below the textarea.
+func (m *Model) resize() {
+	height := m.height - 4
+	if popup := m.popupView(); popup != "" {
+		height -= lipgloss.Height(popup)
+	}
+	m.textarea.SetHeight(max(height, 1))
+
+	m.textarea.SetWidth(m.width)
+	if m.preview != nil {
+		m.textarea.SetWidth(m.width / 2)
+		m.preview.setSize(m.width-m.width/2, m.textarea.Height()+m.textarea.FocusedStyle.Base.GetVerticalFrameSize())
+	}
 }
 
 func (m *Model) SetWidth(width int) {
-	m.textarea.SetWidth(width)
+	m.width = width
+	m.resize()
 }
 
 func (m Model) Init() tea.Cmd {
@@ -80,33 +149,144 @@ func (m Model) Init() tea.Cmd {
 }
 
 func (m Model) Update(msg tea.Msg) (Model, tea.Cmd) {
+	m, cmd := m.update(msg)
+	return m, tea.Batch(cmd, m.schedulePreview(), m.scheduleAutosave())
+}
+
+func (m Model) update(msg tea.Msg) (Model, tea.Cmd) {
+	var cmd tea.Cmd
+
 	switch msg := msg.(type) {
+	case previewMsg:
+		if m.preview != nil && msg.seq == m.preview.seq {
+			m.renderPreview()
+		}
+		return m, nil
+	case autosaveMsg:
+		if msg.seq != m.autosaveSeq {
+			return m, nil
+		}
+		if err := m.autosave(); err != nil {
+			m.warning = "Couldn't save a draft: " + err.Error()
+		}
+		return m, nil
 	case tea.KeyMsg:
 		switch {
+		case m.confirm != nil:
+			return m, m.updateConfirmation(msg)
 		case key.Matches(msg, m.keyMap.Back):
-			return m, gotescmd.Back
+			return m, m.back()
 		case key.Matches(msg, m.keyMap.Submit):
-			text := m.textarea.Value()
-
-			return m, gotescmd.NewEntry(m.entry.File(), text)
+			return m, m.submit()
+		case m.picker != nil:
+			cmd = m.updatePicker(msg)
+			m.resize()
+			return m, cmd
+		case key.Matches(msg, m.keyMap.Preview):
+			m.togglePreview()
+			return m, nil
+		case key.Matches(msg, m.keyMap.Related):
+			cmd = m.openPicker()
+			m.resize()
+			return m, cmd
+		case m.completion != nil && m.updateCompletion(msg):
+			m.resize()
+			return m, nil
 		}
+		if m.vim != nil {
+			cmd = m.vim.update(&m, msg)
+		} else {
+			m.textarea, cmd = m.textarea.Update(msg)
+		}
+		m.updateCompletions()
+		return m, cmd
 	}
 
-	var cmd tea.Cmd
+	if m.picker != nil {
+		m.picker.input, cmd = m.picker.input.Update(msg)
+		return m, cmd
+	}
 
 	m.textarea, cmd = m.textarea.Update(msg)
 
 	return m, cmd
 }
 
+// updateCompletions offers completions while typing after a trigger like "{$".
+func (m *Model) updateCompletions() {
+	m.completion = nil
+	if m.vim == nil || m.vim.mode == insertMode {
+		m.completion = m.complete()
+	}
+	m.resize()
+}
+
+// dirty returns whether the text differs from the entry being edited.
+func (m Model) dirty() bool {
+	return m.textarea.Value() != m.entry.String()
+}
+
+// submit saves the entry. The draft is kept until it was written.
+func (m *Model) submit() tea.Cmd {
+	return gotescmd.NewEntry(m.entry.File(), m.textarea.Value())
+}
+
+// back asks before discarding unsaved changes.
+func (m *Model) back() tea.Cmd {
+	if !m.dirty() {
+		m.RemoveDraft()
+		return gotescmd.Back
+	}
+	m.confirm = &confirmation{
+		question: "Discard your changes?",
+		yes: func(m *Model) tea.Cmd {
+			return m.discard()
+		},
+	}
+	return nil
+}
+
+func (m *Model) discard() tea.Cmd {
+	m.RemoveDraft()
+	return gotescmd.Back
+}
+
 func (m Model) View() string {
-	return m.textarea.View() + gap + m.helpView()
+	view := m.textarea.View()
+	if m.preview != nil {
+		view = lipgloss.JoinHorizontal(lipgloss.Top, view, m.preview.view())
+	}
+	if popup := m.popupView(); popup != "" {
+		view += "\n" + popup
+	}
+	if m.confirm != nil {
+		return view + "\n" + warningStyle(m.confirm.view()) + "\n" + m.helpView()
+	}
+	if m.warning != "" {
+		return view + "\n" + warningStyle(m.warning) + "\n" + m.helpView()
+	}
+	return view + gap + m.helpView()
+}
+
+func (m Model) popupView() string {
+	switch {
+	case m.picker != nil:
+		related := lo.FilterMap(storage.GetMetadata(m.textarea.Value())["related"], func(r string, index int) (string, bool) {
+			return strings.CutPrefix(r, "id=")
+		})
+		return m.picker.view(related, maxCompletions)
+	case m.completion != nil:
+		return m.completion.view()
+	}
+	return ""
 }
 
 func (m Model) ShortHelp() []key.Binding {
 	return []key.Binding{
 		m.keyMap.Back,
 		m.keyMap.Submit,
+		m.keyMap.Related,
+		m.keyMap.Preview,
 	}
 }
 
@@ -115,17 +295,34 @@ func (m Model) FullHelp() [][]key.Binding {
 }
 
 func (m Model) helpView() string {
+	if m.vim != nil {
+		return m.vim.view() + helpStyle(m.help.View(m))
+	}
 	return helpStyle(m.help.View(m))
 }
 
 type keyMap struct {
-	Back   key.Binding
-	Submit key.Binding
+	Back           key.Binding
+	Submit         key.Binding
+	Related        key.Binding
+	Preview        key.Binding
+	CompletionUp   key.Binding
+	CompletionDown key.Binding
+	Accept         key.Binding
+	Toggle         key.Binding
+	Dismiss        key.Binding
 }
 
 func defaultKeyMap() keyMap {
 	return keyMap{
-		Back:   key.NewBinding(key.WithKeys("ctrl+c"), key.WithHelp("ctrl+c", "back")),
-		Submit: key.NewBinding(key.WithKeys("ctrl+s"), key.WithHelp("ctrl+s", "submit")),
+		Back:           key.NewBinding(key.WithKeys("ctrl+c"), key.WithHelp("ctrl+c", "back")),
+		Submit:         key.NewBinding(key.WithKeys("ctrl+s"), key.WithHelp("ctrl+s", "submit")),
+		Related:        key.NewBinding(key.WithKeys("ctrl+t"), key.WithHelp("ctrl+t", "related")),
+		Preview:        key.NewBinding(key.WithKeys("ctrl+o"), key.WithHelp("ctrl+o", "preview")),
+		CompletionUp:   key.NewBinding(key.WithKeys("up", "ctrl+p")),
+		CompletionDown: key.NewBinding(key.WithKeys("down", "ctrl+n")),
+		Accept:         key.NewBinding(key.WithKeys("tab")), // Enter still starts a new line.
+		Toggle:         key.NewBinding(key.WithKeys("tab", "enter")),
+		Dismiss:        key.NewBinding(key.WithKeys("esc")),
 	}
 }
@@ -0,0 +1,117 @@
+package editor
+
+import (
+	"github.com/TotallyNotLost/gotes/config"
+	"github.com/TotallyNotLost/gotes/formatter"
+	"github.com/TotallyNotLost/gotes/markdown"
+	"github.com/TotallyNotLost/gotes/storage"
+	"github.com/charmbracelet/bubbles/viewport"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/samber/lo"
+	"time"
+)
+
+// How long typing has to pause before the preview is rendered again.
+const previewDelay = 300 * time.Millisecond
+
+var (
+	previewStyle = lipgloss.NewStyle().
+			Border(lipgloss.RoundedBorder()).
+			BorderForeground(lipgloss.Color("238")).
+			Padding(0, 1)
+	problemStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("214"))
+)
+
+// preview renders the text being edited the way the viewer will.
+type preview struct {
+	formatter *formatter.MarkdownFormatter
+	parser    *markdown.Parser
+	viewport  viewport.Model
+	// The text that was rendered last, and how it was parsed.
+	text     string
+	doc      *markdown.Document
+	warnings []string
+	// Incremented on every change so that only the last scheduled render happens.
+	seq int
+}
+
+type previewMsg struct {
+	seq int
+}
+
+func newPreview(storage *storage.Storage) *preview {
+	parser := markdown.NewParser(storage)
+	parser.SetMaxDepth(config.MaxIncludeDepth())
+	return &preview{
+		formatter: formatter.NewMarkdownFormatter(storage),
+		parser:    parser,
+		viewport:  viewport.New(0, 0),
+	}
+}
+
+// togglePreview shows or hides the preview next to the textarea.
+func (m *Model) togglePreview() {
+	if m.preview != nil {
+		m.preview = nil
+	} else {
+		m.preview = newPreview(m.storage)
+	}
+	m.resize()
+	m.renderPreview()
+}
+
+// schedulePreview renders the preview once typing pauses.
+func (m *Model) schedulePreview() tea.Cmd {
+	if m.preview == nil || m.preview.text == m.textarea.Value() {
+		return nil
+	}
+	m.preview.seq++
+	msg := previewMsg{seq: m.preview.seq}
+	return tea.Tick(previewDelay, func(time.Time) tea.Msg {
+		return msg
+	})
+}
+
+func (m *Model) renderPreview() {
+	p := m.preview
+	if p == nil {
+		return
+	}
+	p.text = m.textarea.Value()
+	doc := p.parser.ParseIn(p.text, m.entry.File())
+	p.warnings = lo.Map(doc.Unresolved, func(id string, index int) string {
+		return "Couldn't resolve identifier " + id
+	})
+	for _, link := range doc.Ambiguous {
+		p.warnings = append(p.warnings, link+" could link to several entries")
+	}
+	for _, cycle := range doc.Cycles {
+		p.warnings = append(p.warnings, "Include cycle "+cycle.String())
+	}
+	m.resize()
+	p.doc = doc
+	p.viewport.SetContent(p.formatter.Render(doc))
+}
+
+// setSize sizes the preview to fit width and height, including its border.
+func (p *preview) setSize(width int, height int) {
+	frameWidth, frameHeight := previewStyle.GetFrameSize()
+	width -= frameWidth
+	rewrap := p.doc != nil && p.viewport.Width != width
+
+	p.formatter.SetWidth(width)
+	p.viewport.Width = width
+	p.viewport.Height = max(height-frameHeight-len(p.warnings), 1)
+
+	if rewrap {
+		p.viewport.SetContent(p.formatter.Render(p.doc))
+	}
+}
+
+func (p preview) view() string {
+	warnings := lo.Map(p.warnings, func(warning string, index int) string {
+		return problemStyle.Render("⚠ " + warning)
+	})
+	return previewStyle.Render(lipgloss.JoinVertical(lipgloss.Left, append(warnings, p.viewport.View())...))
+}
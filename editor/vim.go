@@ -0,0 +1,808 @@
+package editor
+
+import (
+	"github.com/charmbracelet/bubbles/textarea"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+var (
+	vimModeStyle    = lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color("212")).PaddingLeft(2)
+	vimMessageStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("214"))
+)
+
+type vimMode int
+
+const (
+	normalMode vimMode = iota
+	insertMode
+	visualMode
+	visualLineMode
+)
+
+func (m vimMode) String() string {
+	switch m {
+	case insertMode:
+		return "-- INSERT --"
+	case visualMode:
+		return "-- VISUAL --"
+	case visualLineMode:
+		return "-- VISUAL LINE --"
+	}
+	return "-- NORMAL --"
+}
+
+// vim is an optional modal editing layer on top of the textarea.
+//
+// In insert mode keys go to the textarea. In the other modes they're interpreted here:
+// the text is read from the textarea, changed and written back.
+type vim struct {
+	mode vimMode
+	// Keys of a command that isn't complete yet, e.g. "2", "d" or "g".
+	keys []tea.KeyMsg
+	// Last yanked or deleted text. Linewise text is whole lines, each ending in "\n".
+	register []rune
+	linewise bool
+	undo     []snapshot
+	redo     []snapshot
+	// Keys of the change being made and of the last one, which "." repeats.
+	change     []tea.KeyMsg
+	lastChange []tea.KeyMsg
+	// Whether the text typed in insert mode is part of a change "." can repeat.
+	repeatable bool
+	// Where the selection started in visual mode.
+	anchor int
+	// ":" or "/" while a command or search is being typed.
+	prompt string
+	input  string
+	search string
+	// Shown in the mode line until the next key.
+	message string
+}
+
+type snapshot struct {
+	text   string
+	cursor int
+}
+
+func newVim() *vim {
+	return &vim{}
+}
+
+// reset starts editing new text in normal mode.
+func (v *vim) reset(ta *textarea.Model) {
+	*v = vim{register: v.register, linewise: v.linewise, search: v.search}
+	b := read(ta)
+	b.cursor = 0
+	b.move(ta)
+}
+
+func (v *vim) view() string {
+	switch {
+	case v.prompt != "":
+		return vimModeStyle.Render(v.prompt + v.input)
+	case v.message != "":
+		return vimModeStyle.Render(v.mode.String()) + " " + vimMessageStyle.Render(v.message)
+	}
+	return vimModeStyle.Render(v.mode.String() + " " + keysString(v.keys))
+}
+
+func keysString(keys []tea.KeyMsg) string {
+	var sb strings.Builder
+	for _, k := range keys {
+		sb.WriteString(k.String())
+	}
+	return sb.String()
+}
+
+// update handles a key press. :w submits the entry and :q goes back.
+func (v *vim) update(m *Model, msg tea.KeyMsg) tea.Cmd {
+	v.message = ""
+
+	switch {
+	case v.prompt != "":
+		return v.updatePrompt(m, msg)
+	case v.mode == insertMode:
+		v.change = append(v.change, msg)
+		if msg.Type == tea.KeyEsc {
+			v.mode = normalMode
+			if v.repeatable {
+				v.lastChange = v.change
+			}
+			b := read(&m.textarea)
+			v.commit(b)
+			if b.cursor > b.lineStart(b.cursor) {
+				b.cursor--
+			}
+			b.move(&m.textarea)
+			return nil
+		}
+		var cmd tea.Cmd
+		m.textarea, cmd = m.textarea.Update(msg)
+		return cmd
+	}
+
+	v.keys = append(v.keys, msg)
+	b := read(&m.textarea)
+	cmd, done := v.command(m, &b)
+	if done {
+		v.keys = nil
+	}
+	return cmd
+}
+
+func (v *vim) updatePrompt(m *Model, msg tea.KeyMsg) tea.Cmd {
+	switch msg.Type {
+	case tea.KeyEsc:
+		v.prompt, v.input = "", ""
+	case tea.KeyBackspace:
+		if v.input == "" {
+			v.prompt = ""
+		}
+		v.input = string([]rune(v.input)[:max(0, len([]rune(v.input))-1)])
+	case tea.KeyEnter:
+		prompt, input := v.prompt, v.input
+		v.prompt, v.input = "", ""
+		if prompt == "/" {
+			if input != "" {
+				v.search = input
+			}
+			b := read(&m.textarea)
+			v.find(&b, true)
+			b.move(&m.textarea)
+			return nil
+		}
+		return v.ex(m, strings.TrimSpace(input))
+	case tea.KeyRunes, tea.KeySpace:
+		v.input += string(msg.Runes)
+	}
+	return nil
+}
+
+// ex runs a command typed after ":".
+func (v *vim) ex(m *Model, command string) tea.Cmd {
+	switch command {
+	case "w", "wq", "x":
+		return m.submit()
+	case "q":
+		return m.back()
+	case "q!":
+		return m.discard()
+	case "":
+		return nil
+	}
+	v.message = "Not an editor command: " + command
+	return nil
+}
+
+// command runs the pending keys as a normal or visual mode command.
+// done is false if more keys are needed.
+func (v *vim) command(m *Model, b *buffer) (cmd tea.Cmd, done bool) {
+	ta := &m.textarea
+	count, keys := splitCount(v.keys)
+	if len(keys) == 0 {
+		return nil, false
+	}
+	n := max(count, 1)
+	k := keys[0].String()
+
+	if v.mode == visualMode || v.mode == visualLineMode {
+		return v.visual(m, b, keys, count)
+	}
+
+	// Commands that change the text record their keys for ".".
+	change := func() {
+		v.change = slices.Clone(v.keys)
+		v.lastChange = v.change
+	}
+	insert := func() {
+		v.change = slices.Clone(v.keys)
+		v.repeatable = true
+		v.mode = insertMode
+		b.write(ta)
+	}
+
+	switch k {
+	case "d", "c", "y":
+		if len(keys) == 1 {
+			return nil, false
+		}
+		start, end, linewise, ok, more := v.operatorRange(b, keys, n)
+		if more {
+			return nil, false
+		}
+		if !ok {
+			return nil, true
+		}
+		switch k {
+		case "y":
+			v.yank(b, start, end, linewise)
+			if !linewise {
+				b.cursor = start
+			}
+			b.clamp()
+			b.move(ta)
+		case "d":
+			v.checkpoint(*b)
+			v.delete(b, start, end, linewise)
+			change()
+			v.commit(*b)
+			b.write(ta)
+		case "c":
+			v.checkpoint(*b)
+			if linewise && end > start && b.text[end-1] == '\n' {
+				// Keep the line break so an empty line is left to type in.
+				end--
+			}
+			v.yank(b, start, end, linewise)
+			b.text = slices.Delete(b.text, start, end)
+			b.cursor = start
+			insert()
+		}
+	case "x", "delete":
+		end := min(b.cursor+n, b.lineEnd(b.cursor))
+		if end > b.cursor {
+			v.checkpoint(*b)
+			v.delete(b, b.cursor, end, false)
+			change()
+			v.commit(*b)
+			b.write(ta)
+		}
+	case "X":
+		start := max(b.cursor-n, b.lineStart(b.cursor))
+		if start < b.cursor {
+			v.checkpoint(*b)
+			v.delete(b, start, b.cursor, false)
+			change()
+			v.commit(*b)
+			b.write(ta)
+		}
+	case "D", "C":
+		v.checkpoint(*b)
+		end := b.lineEnd(b.cursor)
+		v.yank(b, b.cursor, end, false)
+		b.text = slices.Delete(b.text, b.cursor, end)
+		if k == "C" {
+			insert()
+			return nil, true
+		}
+		b.clamp()
+		change()
+		v.commit(*b)
+		b.write(ta)
+	case "p", "P":
+		if len(v.register) == 0 {
+			return nil, true
+		}
+		v.checkpoint(*b)
+		v.put(b, k == "p", n)
+		change()
+		v.commit(*b)
+		b.write(ta)
+	case "i", "insert":
+		v.checkpoint(*b)
+		insert()
+	case "a":
+		v.checkpoint(*b)
+		b.cursor = min(b.cursor+1, b.lineEnd(b.cursor))
+		insert()
+	case "I":
+		v.checkpoint(*b)
+		b.cursor = b.firstNonBlank(b.cursor)
+		insert()
+	case "A":
+		v.checkpoint(*b)
+		b.cursor = b.lineEnd(b.cursor)
+		insert()
+	case "o":
+		v.checkpoint(*b)
+		b.cursor = b.lineEnd(b.cursor)
+		b.text = slices.Insert(b.text, b.cursor, '\n')
+		b.cursor++
+		insert()
+	case "O":
+		v.checkpoint(*b)
+		b.cursor = b.lineStart(b.cursor)
+		b.text = slices.Insert(b.text, b.cursor, '\n')
+		insert()
+	case "u":
+		v.restore(b, &v.undo, &v.redo, n)
+		b.write(ta)
+	case "ctrl+r":
+		v.restore(b, &v.redo, &v.undo, n)
+		b.write(ta)
+	case ".":
+		keys := v.lastChange
+		v.keys = nil
+		for _, key := range keys {
+			cmd = tea.Batch(cmd, v.update(m, key))
+		}
+		return cmd, true
+	case "v":
+		v.mode, v.anchor = visualMode, b.cursor
+	case "V":
+		v.mode, v.anchor = visualLineMode, b.cursor
+	case "/":
+		v.prompt = "/"
+	case ":":
+		v.prompt = ":"
+	case "n", "N":
+		v.find(b, k == "n")
+		b.move(ta)
+	case "esc":
+	default:
+		target, _, _, ok, more := b.motion(keys, count)
+		if more {
+			return nil, false
+		}
+		if ok {
+			b.cursor = target
+			b.clamp()
+			b.move(ta)
+		}
+	}
+
+	return nil, true
+}
+
+// visual runs a command in visual mode. Motions extend the selection.
+func (v *vim) visual(m *Model, b *buffer, keys []tea.KeyMsg, count int) (tea.Cmd, bool) {
+	ta := &m.textarea
+	linewise := v.mode == visualLineMode
+	start, end := min(v.anchor, b.cursor), max(v.anchor, b.cursor)+1
+	if linewise {
+		start, end = b.lineStart(start), min(b.lineEnd(end-1)+1, len(b.text))
+	}
+	end = min(end, len(b.text))
+
+	switch k := keys[0].String(); k {
+	case "esc", "ctrl+c":
+		v.mode = normalMode
+	case "v", "V":
+		switch {
+		case k == "v" && v.mode == visualMode, k == "V" && v.mode == visualLineMode:
+			v.mode = normalMode
+		case k == "v":
+			v.mode = visualMode
+		default:
+			v.mode = visualLineMode
+		}
+	case "o":
+		v.anchor, b.cursor = b.cursor, v.anchor
+		b.move(ta)
+	case "y":
+		v.yank(b, start, end, linewise)
+		v.mode = normalMode
+		b.cursor = start
+		b.move(ta)
+	case "d", "x":
+		v.checkpoint(*b)
+		v.delete(b, start, end, linewise)
+		v.commit(*b)
+		v.mode = normalMode
+		b.write(ta)
+	case "c":
+		v.checkpoint(*b)
+		if linewise && end > start && b.text[end-1] == '\n' {
+			end--
+		}
+		v.yank(b, start, end, linewise)
+		b.text = slices.Delete(b.text, start, end)
+		b.cursor = start
+		v.repeatable = false
+		v.mode = insertMode
+		b.write(ta)
+	default:
+		target, _, _, ok, more := b.motion(keys, count)
+		if more {
+			return nil, false
+		}
+		if ok {
+			b.cursor = target
+			b.clamp()
+			b.move(ta)
+		}
+	}
+
+	return nil, true
+}
+
+// operatorRange returns the text an operator like d applies to, given the keys after the operator.
+func (v *vim) operatorRange(b *buffer, keys []tea.KeyMsg, n int) (start int, end int, linewise bool, ok bool, more bool) {
+	op := keys[0].String()
+	count, motion := splitCount(keys[1:])
+	if count > 0 {
+		n *= count
+	}
+	if len(motion) == 0 {
+		return 0, 0, false, false, true
+	}
+
+	// dd, yy and cc work on whole lines.
+	if motion[0].String() == op {
+		row, _ := b.rowCol(b.cursor)
+		first := b.index(row, 0)
+		last := b.index(row+n-1, 0)
+		return first, min(b.lineEnd(last)+1, len(b.text)), true, true, false
+	}
+
+	// cw changes to the end of the word, like ce.
+	if op == "c" && motion[0].String() == "w" && !b.isSpace(b.cursor) {
+		motion = []tea.KeyMsg{{Type: tea.KeyRunes, Runes: []rune("e")}}
+	}
+
+	target, linewise, inclusive, ok, more := b.motion(motion, n)
+	if more || !ok {
+		return 0, 0, false, ok, more
+	}
+
+	start, end = min(b.cursor, target), max(b.cursor, target)
+	if linewise {
+		return b.lineStart(start), min(b.lineEnd(end)+1, len(b.text)), true, true, false
+	}
+	if inclusive {
+		end = min(end+1, len(b.text))
+	}
+	// Word motions don't continue past the end of the line.
+	if motion[0].String() == "w" {
+		end = min(end, b.lineEnd(start))
+	}
+	return start, end, false, true, false
+}
+
+func (v *vim) yank(b *buffer, start int, end int, linewise bool) {
+	v.register = slices.Clone(b.text[start:end])
+	v.linewise = linewise
+	if linewise && (len(v.register) == 0 || v.register[len(v.register)-1] != '\n') {
+		v.register = append(v.register, '\n')
+	}
+}
+
+func (v *vim) delete(b *buffer, start int, end int, linewise bool) {
+	v.yank(b, start, end, linewise)
+	if linewise && end == len(b.text) && start > 0 {
+		// Deleting the last lines removes the line break before them.
+		start--
+	}
+	b.text = slices.Delete(b.text, start, end)
+	b.cursor = start
+	if linewise {
+		b.cursor = b.firstNonBlank(start)
+	}
+	b.clamp()
+}
+
+// put inserts the register n times, after the cursor if after is true.
+func (v *vim) put(b *buffer, after bool, n int) {
+	text := slices.Repeat(v.register, n)
+
+	if !v.linewise {
+		at := b.cursor
+		if after && b.cursor < b.lineEnd(b.cursor) {
+			at++
+		}
+		b.text = slices.Insert(b.text, at, text...)
+		b.cursor = at + len(text) - 1
+		return
+	}
+
+	at, first := b.lineStart(b.cursor), b.lineStart(b.cursor)
+	if after {
+		at = b.lineEnd(b.cursor)
+		if at == len(b.text) {
+			// There's no line break after the last line.
+			text = append([]rune{'\n'}, text[:len(text)-1]...)
+		} else {
+			at++
+		}
+		first = at + 1
+	}
+	b.text = slices.Insert(b.text, at, text...)
+	if !after || text[0] != '\n' {
+		first = at
+	}
+	b.cursor = b.firstNonBlank(first)
+}
+
+// checkpoint records the text before a change so it can be undone.
+func (v *vim) checkpoint(b buffer) {
+	v.undo = append(v.undo, snapshot{string(b.text), b.cursor})
+	v.redo = nil
+}
+
+// commit drops the last checkpoint if nothing changed since.
+func (v *vim) commit(b buffer) {
+	if n := len(v.undo); n > 0 && v.undo[n-1].text == string(b.text) {
+		v.undo = v.undo[:n-1]
+	}
+}
+
+// restore moves n snapshots from one stack to the other, e.g. from undo to redo.
+func (v *vim) restore(b *buffer, from *[]snapshot, to *[]snapshot, n int) {
+	if len(*from) == 0 {
+		v.message = "Already at the oldest change"
+		if from == &v.redo {
+			v.message = "Already at the newest change"
+		}
+		return
+	}
+	for range n {
+		if len(*from) == 0 {
+			break
+		}
+		s := (*from)[len(*from)-1]
+		*from = (*from)[:len(*from)-1]
+		*to = append(*to, snapshot{string(b.text), b.cursor})
+		b.text, b.cursor = []rune(s.text), s.cursor
+	}
+	b.clamp()
+}
+
+// find moves to the next (or previous) match of the last search, wrapping around.
+func (v *vim) find(b *buffer, forward bool) {
+	if v.search == "" {
+		v.message = "No previous search"
+		return
+	}
+	pattern := []rune(v.search)
+	matches := func(i int) bool {
+		return i+len(pattern) <= len(b.text) && slices.Equal(b.text[i:i+len(pattern)], pattern)
+	}
+
+	for step := 1; step <= len(b.text); step++ {
+		i := b.cursor + step
+		if !forward {
+			i = b.cursor - step
+		}
+		i = (i%len(b.text) + len(b.text)) % len(b.text)
+		if matches(i) {
+			b.cursor = i
+			return
+		}
+	}
+	v.message = "Pattern not found: " + v.search
+}
+
+// splitCount splits a leading count like "12" off keys. The count is 0 if there isn't one.
+func splitCount(keys []tea.KeyMsg) (int, []tea.KeyMsg) {
+	digits := ""
+	for _, k := range keys {
+		s := k.String()
+		if len(s) != 1 || s[0] < '0' || s[0] > '9' || (s == "0" && digits == "") {
+			break
+		}
+		digits += s
+	}
+	count, _ := strconv.Atoi(digits)
+	return count, keys[len(digits):]
+}
+
+// buffer is the textarea's text with the cursor as an index into it.
+type buffer struct {
+	text   []rune
+	cursor int
+}
+
+func read(ta *textarea.Model) buffer {
+	b := buffer{text: []rune(ta.Value())}
+	li := ta.LineInfo()
+	b.cursor = b.index(ta.Line(), li.StartColumn+li.ColumnOffset)
+	return b
+}
+
+// write replaces the textarea's text with b's.
+func (b buffer) write(ta *textarea.Model) {
+	ta.SetValue(string(b.text))
+	b.move(ta)
+}
+
+// move moves the textarea's cursor to b's.
+func (b buffer) move(ta *textarea.Model) {
+	row, col := b.rowCol(b.cursor)
+	for ta.Line() > row {
+		line := ta.Line()
+		ta.CursorUp()
+		if ta.Line() == line && ta.LineInfo().RowOffset == 0 {
+			break
+		}
+	}
+	for ta.Line() < row {
+		line := ta.Line()
+		ta.CursorDown()
+		if ta.Line() == line && ta.LineInfo().RowOffset == ta.LineInfo().Height-1 {
+			break
+		}
+	}
+	ta.SetCursor(col)
+}
+
+func (b buffer) lineStart(i int) int {
+	for i > 0 && b.text[i-1] != '\n' {
+		i--
+	}
+	return i
+}
+
+// lineEnd returns the index of the line break ending the line, or the length of the text.
+func (b buffer) lineEnd(i int) int {
+	for i < len(b.text) && b.text[i] != '\n' {
+		i++
+	}
+	return i
+}
+
+func (b buffer) rowCol(i int) (int, int) {
+	i = min(i, len(b.text))
+	row := 0
+	for _, r := range b.text[:i] {
+		if r == '\n' {
+			row++
+		}
+	}
+	return row, i - b.lineStart(i)
+}
+
+// index returns the index of col in row, both clamped to the text.
+func (b buffer) index(row int, col int) int {
+	i := 0
+	for ; row > 0; row-- {
+		end := b.lineEnd(i)
+		if end == len(b.text) {
+			break
+		}
+		i = end + 1
+	}
+	return min(i+col, b.lineEnd(i))
+}
+
+func (b buffer) lastRow() int {
+	row, _ := b.rowCol(len(b.text))
+	return row
+}
+
+func (b buffer) firstNonBlank(i int) int {
+	i = b.lineStart(i)
+	for i < len(b.text) && (b.text[i] == ' ' || b.text[i] == '\t') {
+		i++
+	}
+	return i
+}
+
+// clamp keeps the cursor on a character in normal mode, rather than after the end of the line.
+func (b *buffer) clamp() {
+	b.cursor = max(0, min(b.cursor, len(b.text)))
+	if b.cursor == b.lineEnd(b.cursor) && b.cursor > b.lineStart(b.cursor) {
+		b.cursor--
+	}
+}
+
+func (b buffer) isSpace(i int) bool {
+	return i >= len(b.text) || unicode.IsSpace(b.text[i])
+}
+
+// class is the kind of character at i. Words are runs of characters of the same class.
+func (b buffer) class(i int) int {
+	switch r := b.text[i]; {
+	case unicode.IsSpace(r):
+		return 0
+	case r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r):
+		return 1
+	}
+	return 2
+}
+
+// emptyLine returns whether i is on an empty line, which counts as a word.
+func (b buffer) emptyLine(i int) bool {
+	return b.lineStart(i) == b.lineEnd(i)
+}
+
+func (b buffer) wordStart(i int) int {
+	if i >= len(b.text) {
+		return i
+	}
+	if c := b.class(i); c != 0 {
+		for i < len(b.text) && b.class(i) == c {
+			i++
+		}
+	} else if b.emptyLine(i) {
+		i++
+	}
+	for i < len(b.text) && b.class(i) == 0 && !b.emptyLine(i) {
+		i++
+	}
+	return min(i, len(b.text))
+}
+
+func (b buffer) wordBackward(i int) int {
+	i--
+	for i > 0 && b.class(i) == 0 && !b.emptyLine(i) {
+		i--
+	}
+	if i <= 0 {
+		return 0
+	}
+	if b.class(i) == 0 {
+		return i
+	}
+	c := b.class(i)
+	for i > 0 && b.class(i-1) == c {
+		i--
+	}
+	return i
+}
+
+func (b buffer) wordEnd(i int) int {
+	i++
+	for i < len(b.text) && b.class(i) == 0 {
+		i++
+	}
+	if i >= len(b.text) {
+		return max(0, len(b.text)-1)
+	}
+	c := b.class(i)
+	for i+1 < len(b.text) && b.class(i+1) == c {
+		i++
+	}
+	return i
+}
+
+// motion returns where keys move the cursor to, repeated count times.
+// more is true if the motion isn't complete, e.g. after a single "g".
+func (b buffer) motion(keys []tea.KeyMsg, count int) (target int, linewise bool, inclusive bool, ok bool, more bool) {
+	if len(keys) == 0 {
+		return 0, false, false, false, true
+	}
+	n := max(count, 1)
+	i := b.cursor
+	row, col := b.rowCol(i)
+
+	switch keys[0].String() {
+	case "h", "left", "backspace":
+		return max(i-n, b.lineStart(i)), false, false, true, false
+	case "l", "right", " ":
+		return min(i+n, b.lineEnd(i)), false, false, true, false
+	case "j", "down", "enter":
+		return b.index(min(row+n, b.lastRow()), col), true, false, true, false
+	case "k", "up":
+		return b.index(max(row-n, 0), col), true, false, true, false
+	case "w":
+		for range n {
+			i = b.wordStart(i)
+		}
+		return i, false, false, true, false
+	case "b":
+		for range n {
+			i = b.wordBackward(i)
+		}
+		return i, false, false, true, false
+	case "e":
+		for range n {
+			i = b.wordEnd(i)
+		}
+		return i, false, true, true, false
+	case "0", "home":
+		return b.lineStart(i), false, false, true, false
+	case "^":
+		return b.firstNonBlank(i), false, false, true, false
+	case "$", "end":
+		return max(b.lineEnd(i)-1, b.lineStart(i)), false, true, true, false
+	case "G":
+		if count == 0 {
+			return b.firstNonBlank(b.index(b.lastRow(), 0)), true, false, true, false
+		}
+		return b.firstNonBlank(b.index(count-1, 0)), true, false, true, false
+	case "g":
+		if len(keys) == 1 {
+			return 0, false, false, false, true
+		}
+		if keys[1].String() == "g" {
+			return b.firstNonBlank(b.index(max(count-1, 0), 0)), true, false, true, false
+		}
+	}
+
+	return 0, false, false, false, false
+}
@@ -0,0 +1,128 @@
+package formatter
+
+import (
+	"github.com/TotallyNotLost/gotes/diff"
+	"github.com/TotallyNotLost/gotes/storage"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/samber/lo"
+	"strings"
+)
+
+var (
+	insertStyle        = lipgloss.NewStyle().Foreground(lipgloss.Color("2"))
+	deleteStyle        = lipgloss.NewStyle().Foreground(lipgloss.Color("1"))
+	insertedWordStyle  = insertStyle.Reverse(true)
+	deletedWordStyle   = deleteStyle.Reverse(true)
+	metadataTitleStyle = lipgloss.NewStyle().Bold(true)
+	unchangedStyle     = lipgloss.NewStyle().Foreground(lipgloss.Color("241"))
+)
+
+// NewDiffFormatter returns a formatter that shows what changed between the base text and the formatted text.
+func NewDiffFormatter() *DiffFormatter {
+	return &DiffFormatter{}
+}
+
+type DiffFormatter struct {
+	base string
+}
+
+func (df *DiffFormatter) SetBase(base string) {
+	df.base = base
+}
+
+func (df *DiffFormatter) Format(s string) string {
+	var sb strings.Builder
+
+	if changes := diff.Metadata(storage.GetMetadata(df.base), storage.GetMetadata(s)); len(changes) > 0 {
+		sb.WriteString(metadataTitleStyle.Render("Metadata") + "\n")
+		for _, c := range changes {
+			sb.WriteString(style(c.Kind).Render(c.String()) + "\n")
+		}
+		sb.WriteString("\n")
+	}
+
+	ops := diff.Lines(storage.StripMetadata(df.base), storage.StripMetadata(s))
+	if sb.Len() == 0 && !lo.ContainsBy(ops, func(op diff.Op) bool { return op.Kind != diff.Equal }) {
+		return unchangedStyle.Render("No changes")
+	}
+
+	for i := 0; i < len(ops); {
+		if ops[i].Kind == diff.Equal {
+			sb.WriteString(unchangedStyle.Render("  "+ops[i].Text) + "\n")
+			i++
+			continue
+		}
+
+		// A run of deleted lines followed by as many inserted lines is shown word by word.
+		deletes := i
+		for deletes < len(ops) && ops[deletes].Kind == diff.Delete {
+			deletes++
+		}
+		inserts := deletes
+		for inserts < len(ops) && ops[inserts].Kind == diff.Insert {
+			inserts++
+		}
+
+		if n := deletes - i; n > 0 && inserts-deletes == n {
+			var added []string
+			for j := range n {
+				removed, inserted := wordDiff(ops[i+j].Text, ops[deletes+j].Text)
+				sb.WriteString(removed + "\n")
+				added = append(added, inserted)
+			}
+			for _, a := range added {
+				sb.WriteString(a + "\n")
+			}
+			i = inserts
+			continue
+		}
+
+		for _, op := range ops[i:inserts] {
+			sb.WriteString(style(op.Kind).Render(marker(op.Kind)+op.Text) + "\n")
+		}
+		i = inserts
+	}
+
+	return strings.TrimSuffix(sb.String(), "\n")
+}
+
+// wordDiff renders the removed and inserted versions of a changed line
+// with the words that changed highlighted.
+func wordDiff(a string, b string) (string, string) {
+	removed := deleteStyle.Render(marker(diff.Delete))
+	inserted := insertStyle.Render(marker(diff.Insert))
+
+	for _, op := range diff.Words(a, b) {
+		switch op.Kind {
+		case diff.Equal:
+			removed += deleteStyle.Render(op.Text)
+			inserted += insertStyle.Render(op.Text)
+		case diff.Delete:
+			removed += deletedWordStyle.Render(op.Text)
+		case diff.Insert:
+			inserted += insertedWordStyle.Render(op.Text)
+		}
+	}
+
+	return removed, inserted
+}
+
+func style(k diff.Kind) lipgloss.Style {
+	switch k {
+	case diff.Insert:
+		return insertStyle
+	case diff.Delete:
+		return deleteStyle
+	}
+	return unchangedStyle
+}
+
+func marker(k diff.Kind) string {
+	switch k {
+	case diff.Insert:
+		return "+ "
+	case diff.Delete:
+		return "- "
+	}
+	return "  "
+}
@@ -1,36 +1,72 @@
 package formatter
 
 import (
+	"github.com/TotallyNotLost/gotes/config"
 	"github.com/TotallyNotLost/gotes/markdown"
 	"github.com/TotallyNotLost/gotes/storage"
-	"github.com/charmbracelet/glamour"
+	"github.com/charmbracelet/glamour/ansi"
+	"github.com/charmbracelet/glamour/styles"
+	"github.com/charmbracelet/lipgloss"
 )
 
+// Output fits in glamour's default width of 80 columns until SetWidth is called.
+const defaultWidth = 80
+
+// How many renderings Format remembers before it forgets them all.
+const maxMemo = 64
+
 func NewMarkdownFormatter(storage *storage.Storage) *MarkdownFormatter {
-	renderer, _ := glamour.NewTermRenderer(
-		// detect background color and pick either the default dark or light theme
-		glamour.WithAutoStyle(),
-		// wrap output at specific width (default is 80)
-		// glamour.WithWordWrap(mf.width),
-	)
+	parser := markdown.NewParser(storage)
+	parser.SetMaxDepth(config.MaxIncludeDepth())
 	return &MarkdownFormatter{
-		renderer: renderer,
-		parser: markdown.NewParser(storage),
+		renderer: newRenderer(defaultWidth),
+		parser: parser,
+		width:  defaultWidth,
 		memo:   make(map[string]string),
 	}
 }
 
+func newRenderer(width int) *markdown.TerminalRenderer {
+	// detect background color and pick either the default dark or light theme
+	style := styles.LightStyleConfig
+	if lipgloss.HasDarkBackground() {
+		style = styles.DarkStyleConfig
+	}
+	// glamour wraps the text within the document's margin.
+	if style.Document.Margin != nil {
+		width -= int(*style.Document.Margin)
+	}
+	return markdown.NewTerminalRenderer(ansi.Options{
+		WordWrap:     max(width, 1),
+		ColorProfile: lipgloss.ColorProfile(),
+		Styles:       style,
+	})
+}
+
 type MarkdownFormatter struct {
-	renderer *glamour.TermRenderer
+	renderer *markdown.TerminalRenderer
 	parser *markdown.Parser
 	width  int
 	memo   map[string]string
 }
 
+// SetWidth wraps the output at width.
 func (mf *MarkdownFormatter) SetWidth(width int) {
+	if width <= 0 || width == mf.width {
+		return
+	}
 	mf.width = width
+	mf.renderer = newRenderer(width)
+	// Everything rendered so far was wrapped at the old width.
+	mf.ClearCache()
+}
+
+// ClearCache forgets what Format rendered, e.g. after the entries it included or linked to changed.
+func (mf *MarkdownFormatter) ClearCache() {
+	clear(mf.memo)
 }
 
+// Format renders s, remembering the result until ClearCache is called.
 func (mf *MarkdownFormatter) Format(s string) string {
 	var (
 		f  string
@@ -39,6 +75,9 @@ func (mf *MarkdownFormatter) Format(s string) string {
 
 	if f, ok = mf.memo[s]; !ok {
 		f = mf.format(s)
+		if len(mf.memo) >= maxMemo {
+			clear(mf.memo)
+		}
 		mf.memo[s] = f
 	}
 
@@ -46,7 +85,11 @@ func (mf *MarkdownFormatter) Format(s string) string {
 }
 
 func (mf *MarkdownFormatter) format(s string) string {
-	expanded, _ := mf.parser.Expand(s)
-	md, _ := mf.renderer.Render(expanded)
+	return mf.Render(mf.parser.Parse(s))
+}
+
+// Render renders a document that was already parsed. Unlike Format it doesn't remember the result.
+func (mf *MarkdownFormatter) Render(doc *markdown.Document) string {
+	md, _ := mf.renderer.Render(doc)
 	return md
 }
@@ -3,13 +3,17 @@ package list
 import (
 	"fmt"
 	gotescmd "github.com/TotallyNotLost/gotes/cmd"
+	"github.com/TotallyNotLost/gotes/config"
+	"github.com/TotallyNotLost/gotes/query"
+	"github.com/TotallyNotLost/gotes/search"
 	"github.com/TotallyNotLost/gotes/storage"
+	"github.com/charmbracelet/bubbles/key"
 	"github.com/charmbracelet/bubbles/list"
+	"github.com/charmbracelet/bubbles/textinput"
 	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
 	"github.com/google/uuid"
 	"github.com/samber/lo"
-	"os"
-	"slices"
 	"strings"
 )
 
@@ -17,33 +21,71 @@ func EntryToItem(s *storage.Storage, entry storage.Entry) *Item {
 	var getLatestEntry = func(id string) (storage.Entry, bool) {
 		return s.GetLatest(id)
 	}
-	return &Item{entry: entry, getLatestEntry: getLatestEntry}
+	item := &Item{entry: entry, getLatestEntry: getLatestEntry}
+	if entry.IsDeleted() {
+		item.title = s.Title(entry)
+	}
+	return item
 }
 
 type Item struct {
 	storage        *storage.Storage
 	entry          storage.Entry
 	getLatestEntry func(id string) (storage.Entry, bool)
-}
-
-func title(entry storage.Entry) string {
-	return lo.FirstOrEmpty(strings.Split(entry.Text(), "\n"))
+	// Shown instead of the related entries when searching.
+	snippet string
+	// Shown instead of the entry's own title, if set.
+	title string
 }
 
 func (i *Item) Entry() storage.Entry { return i.entry }
 func (i *Item) File() string         { return i.entry.File() }
-func (i *Item) Title() string        { return title(i.entry) }
+func (i *Item) Title() string {
+	title := lo.CoalesceOrEmpty(i.title, i.entry.Title())
+	if status := i.entry.Status(); status != "" {
+		return title + " (" + status + ")"
+	}
+	return title
+}
 func (i *Item) Description() string {
+	if i.snippet != "" {
+		return i.snippet
+	}
 	tags := lo.Map(i.entry.RelatedIds(), func(id string, index int) string {
 		entry, _ := i.getLatestEntry(id)
-		return title(entry)
+		return entry.Title()
 	})
 	return strings.Join(tags, ",")
 }
 func (i *Item) FilterValue() string { return i.Title() + " " + i.Description() }
 
+// SetSnippet shows snippet instead of the related entries.
+func (i *Item) SetSnippet(snippet string) { i.snippet = snippet }
+
 type Model struct {
 	list list.Model
+	// File new entries are created in.
+	file string
+	// Every item, and the items matching the query before searching.
+	all       []*Item
+	items     []*Item
+	index     *search.Index
+	searching bool
+	input     textinput.Model
+	// Only items matching the query of the active view are shown.
+	views        []config.View
+	view         int
+	query        query.Query
+	editingQuery bool
+	queryInput   textinput.Model
+	queryErr     error
+	// Archived and deleted entries are only shown when true.
+	showArchived bool
+	// The item waiting for its deletion to be confirmed.
+	deleting *Item
+	title    string
+	height   int
+	width    int
 }
 
 func (model Model) Init() tea.Cmd {
@@ -60,46 +102,292 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			m.list, cmd = m.list.Update(msg)
 			return m, cmd
 		}
+		if m.searching {
+			return m.updateSearch(msg)
+		}
+		if m.editingQuery {
+			return m.updateQuery(msg)
+		}
+		if m.deleting != nil {
+			return m.updateDelete(msg)
+		}
+		switch {
+		case key.Matches(msg, keys.Search) && m.index != nil:
+			m.searching = true
+			m.input.SetValue("")
+			m.input.Focus()
+			m.resize()
+			return m, textinput.Blink
+		case key.Matches(msg, keys.Query):
+			m.editingQuery = true
+			m.queryInput.SetValue(m.query.String())
+			m.queryInput.CursorEnd()
+			m.queryInput.Focus()
+			m.resize()
+			return m, textinput.Blink
+		case key.Matches(msg, keys.NextView) && len(m.views) > 0:
+			m.SetView((m.view + 1) % len(m.views))
+			return m, nil
+		case key.Matches(msg, keys.ShowArchived):
+			m.showArchived = !m.showArchived
+			m.updateTitle()
+			m.SetItems(m.all)
+			return m, nil
+		case key.Matches(msg, keys.Archive):
+			if i := m.SelectedItem(); i != nil && !i.entry.IsDeleted() {
+				if i.entry.IsArchived() {
+					return m, gotescmd.UnarchiveEntry(i.entry)
+				}
+				return m, gotescmd.ArchiveEntry(i.entry)
+			}
+		case key.Matches(msg, keys.Delete):
+			if i := m.SelectedItem(); i != nil && !i.entry.IsDeleted() {
+				m.deleting = i
+				m.resize()
+				return m, nil
+			}
+		}
 		switch msg.String() {
 		case "enter":
-			i := m.SelectedItem()
-			return m, gotescmd.ViewEntry(i.entry)
+			if i := m.SelectedItem(); i != nil {
+				return m, gotescmd.ViewEntry(i.entry)
+			}
 		case "n":
 			text := fmt.Sprintf("[_metadata_:id]:# \"%s\"\n[_metadata_:related]:# \"\"", uuid.New().String())
-			entry := storage.NewEntry(os.Args[1], text, 0, 0, 0)
+			entry := storage.NewEntry(m.file, text, 0, 0, 0)
 			return m, gotescmd.EditEntry(entry)
 		case "e":
 			i, ok := m.list.SelectedItem().(*Item)
 			if ok {
 				return m, gotescmd.EditEntry(i.entry)
 			}
+		case "E":
+			if i := m.SelectedItem(); i != nil {
+				return m, gotescmd.EditEntryExternally(i.entry)
+			}
 		}
 	}
 	m.list, cmd = m.list.Update(msg)
 	return m, tea.Batch(cmd, vcmd)
 }
 
+// updateDelete deletes the entry if the deletion is confirmed with y and cancels it otherwise.
+func (m Model) updateDelete(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	i := m.deleting
+	m.deleting = nil
+	m.resize()
+
+	if msg.String() == "y" || msg.String() == "Y" {
+		return m, gotescmd.DeleteEntry(i.entry)
+	}
+	return m, nil
+}
+
+func (m Model) updateSearch(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	var cmd tea.Cmd
+
+	switch {
+	case key.Matches(msg, keys.CancelSearch):
+		m.searching = false
+		m.input.Blur()
+		m.resize()
+		m.setListItems(m.items)
+		return m, nil
+	case key.Matches(msg, keys.View):
+		if i := m.SelectedItem(); i != nil {
+			return m, gotescmd.ViewEntry(i.entry)
+		}
+		return m, nil
+	case key.Matches(msg, m.list.KeyMap.CursorUp, m.list.KeyMap.CursorDown) && msg.Type != tea.KeyRunes:
+		m.list, cmd = m.list.Update(msg)
+		return m, cmd
+	}
+
+	m.input, cmd = m.input.Update(msg)
+	m.search()
+	return m, cmd
+}
+
+func (m Model) updateQuery(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	var cmd tea.Cmd
+
+	switch {
+	case key.Matches(msg, keys.CancelSearch):
+		m.editingQuery = false
+		m.queryErr = nil
+		m.queryInput.Blur()
+		m.resize()
+		return m, nil
+	case key.Matches(msg, keys.View):
+		q, err := query.Parse(m.queryInput.Value())
+		m.queryErr = err
+		if err != nil {
+			m.resize()
+			return m, nil
+		}
+		m.editingQuery = false
+		m.queryInput.Blur()
+		m.resize()
+		m.query = q
+		m.updateTitle()
+		m.SetItems(m.all)
+		return m, nil
+	}
+
+	m.queryInput, cmd = m.queryInput.Update(msg)
+	return m, cmd
+}
+
+// search shows the items whose body matches the search input.
+func (m *Model) search() {
+	query := m.input.Value()
+	if strings.TrimSpace(query) == "" {
+		m.setListItems(m.items)
+		return
+	}
+
+	byId := lo.KeyBy(m.items, func(item *Item) string {
+		return item.entry.Id()
+	})
+
+	var items []*Item
+	for _, r := range m.index.Search(query) {
+		item, ok := byId[r.Entry.Id()]
+		if !ok {
+			continue
+		}
+		found := *item
+		found.snippet = r.Snippet(max(m.width, 20), func(s string) string {
+			return highlightStyle.Render(s)
+		})
+		items = append(items, &found)
+	}
+
+	m.setListItems(items)
+}
+
+// Select selects the item for the entry with the given id, if it's shown.
+func (m *Model) Select(id string) {
+	for i, item := range m.list.Items() {
+		if item.(*Item).entry.Id() == id {
+			m.list.Select(i)
+			return
+		}
+	}
+}
+
+// SelectedItem returns the selected item, or nil if the list is empty.
 func (m Model) SelectedItem() *Item {
-	return m.list.SelectedItem().(*Item)
+	i, _ := m.list.SelectedItem().(*Item)
+	return i
 }
 
 func (m Model) View() string {
+	if header := m.headerView(); header != "" {
+		return lipgloss.JoinVertical(lipgloss.Left, header, m.list.View())
+	}
 	return m.list.View()
 }
 
+func (m Model) headerView() string {
+	switch {
+	case m.deleting != nil:
+		return confirmStyle.Render(fmt.Sprintf("Delete %q? (y/n)", m.deleting.Title()))
+	case m.searching:
+		return m.input.View()
+	case m.editingQuery && m.queryErr != nil:
+		return lipgloss.JoinVertical(lipgloss.Left, m.queryInput.View(), errorStyle.Render(m.queryErr.Error()))
+	case m.editingQuery:
+		return m.queryInput.View()
+	}
+	return ""
+}
+
 func (m *Model) SetTitle(title string) {
-	m.list.Title = title
+	m.title = title
+	m.updateTitle()
+}
+
+func (m *Model) updateTitle() {
+	m.list.Title = m.title
+	if m.view < len(m.views) && m.query.String() == m.views[m.view].Query {
+		m.list.Title += " · " + m.views[m.view].Name
+	} else if !m.query.Empty() {
+		m.list.Title += " · " + m.query.String()
+	}
+	if m.showArchived {
+		m.list.Title += " · with archived"
+	}
+}
+
+// SetViews sets the saved views that can be cycled through.
+// The first view is made active.
+func (m *Model) SetViews(views []config.View) {
+	m.views = views
+	m.SetView(0)
+}
+
+// SetView makes the i-th saved view active.
+func (m *Model) SetView(i int) {
+	m.view = i
+	m.query = query.Query{}
+	if i < len(m.views) {
+		q, err := query.Parse(m.views[i].Query)
+		if err == nil {
+			m.query = q
+		}
+		m.queryErr = err
+	}
+	m.updateTitle()
+	m.SetItems(m.all)
+}
+
+// SetFile sets the file that new entries are created in.
+func (m *Model) SetFile(file string) {
+	m.file = file
+}
+
+// SetIndex sets the index used to search the body of entries.
+func (m *Model) SetIndex(index *search.Index) {
+	m.index = index
+	if m.searching {
+		m.search()
+	}
 }
 
 func (m *Model) SetSize(width int, height int) {
-	m.list.SetSize(width, height)
+	m.width, m.height = width, height
+	m.resize()
+}
+
+func (m *Model) resize() {
+	height := m.height
+	if header := m.headerView(); header != "" {
+		height -= lipgloss.Height(header)
+	}
+	m.list.SetSize(m.width, height)
+	m.input.Width = m.width - lipgloss.Width(m.input.Prompt) - 1
+	m.queryInput.Width = m.width - lipgloss.Width(m.queryInput.Prompt) - 1
 }
 
+// SetItems sets the items to show, hiding the ones that don't match the active query
+// and archived or deleted ones unless they're being shown.
 func (m *Model) SetItems(items []*Item) {
-	notDoneItems := lo.Filter(items, func(item *Item, index int) bool {
-		return !slices.Contains(item.entry.RelatedIds(), "#Done")
+	m.all = items
+	m.items = lo.Filter(items, func(item *Item, index int) bool {
+		return (m.showArchived || !item.entry.IsHidden()) && m.query.Match(item.entry)
 	})
-	m.list.SetItems(lo.Map(notDoneItems, func(item *Item, index int) list.Item {
+
+	if m.searching {
+		m.search()
+		return
+	}
+
+	m.setListItems(m.items)
+}
+
+func (m *Model) setListItems(items []*Item) {
+	m.list.SetItems(lo.Map(items, func(item *Item, index int) list.Item {
 		return item
 	}))
 }
@@ -118,13 +406,57 @@ func (m *Model) SetFocused(focused bool) {
 }
 
 func New() Model {
+	input := textinput.New()
+	input.Prompt = "Search: "
+	input.Placeholder = "words or \"a phrase\""
+
+	queryInput := textinput.New()
+	queryInput.Prompt = "Query: "
+	queryInput.Placeholder = "tag:#Project -tag:#Done"
+
 	return Model{
-		list: newList(),
+		list:       newList(),
+		input:      input,
+		queryInput: queryInput,
+		views:      []config.View{config.DefaultView},
+		query:      query.MustParse(config.DefaultView.Query),
 	}
 }
 
 func newList() list.Model {
 	l := list.New([]list.Item{}, list.NewDefaultDelegate(), 0, 0)
+	l.AdditionalShortHelpKeys = func() []key.Binding {
+		return []key.Binding{keys.Search, keys.Query, keys.NextView}
+	}
+	l.AdditionalFullHelpKeys = func() []key.Binding {
+		return []key.Binding{keys.Search, keys.Query, keys.NextView, keys.Archive, keys.Delete, keys.ShowArchived}
+	}
 
 	return l
 }
+
+var (
+	highlightStyle = lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color("212"))
+	errorStyle     = lipgloss.NewStyle().Foreground(lipgloss.Color("196"))
+	confirmStyle   = lipgloss.NewStyle().Foreground(lipgloss.Color("214"))
+)
+
+var keys = struct {
+	Search       key.Binding
+	Query        key.Binding
+	NextView     key.Binding
+	CancelSearch key.Binding
+	View         key.Binding
+	Archive      key.Binding
+	Delete       key.Binding
+	ShowArchived key.Binding
+}{
+	Search:       key.NewBinding(key.WithKeys("s"), key.WithHelp("s", "search body")),
+	Query:        key.NewBinding(key.WithKeys(":"), key.WithHelp(":", "query")),
+	NextView:     key.NewBinding(key.WithKeys("v"), key.WithHelp("v", "next view")),
+	CancelSearch: key.NewBinding(key.WithKeys("esc"), key.WithHelp("esc", "cancel search")),
+	View:         key.NewBinding(key.WithKeys("enter"), key.WithHelp("enter", "view")),
+	Archive:      key.NewBinding(key.WithKeys("a"), key.WithHelp("a", "archive/unarchive")),
+	Delete:       key.NewBinding(key.WithKeys("D"), key.WithHelp("D", "delete")),
+	ShowArchived: key.NewBinding(key.WithKeys("A"), key.WithHelp("A", "show archived")),
+}
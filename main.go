@@ -3,17 +3,23 @@ package main
 import (
 	"fmt"
 	gotescmd "github.com/TotallyNotLost/gotes/cmd"
+	"github.com/TotallyNotLost/gotes/config"
 	"github.com/TotallyNotLost/gotes/editor"
 	"github.com/TotallyNotLost/gotes/list"
 	"github.com/TotallyNotLost/gotes/markdown"
+	"github.com/TotallyNotLost/gotes/search"
 	"github.com/TotallyNotLost/gotes/storage"
 	"github.com/TotallyNotLost/gotes/viewer"
+	"github.com/TotallyNotLost/gotes/watch"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
 	"github.com/charmbracelet/log"
 	"github.com/samber/lo"
 	"os"
+	"path/filepath"
 	"slices"
+	"strings"
+	"time"
 )
 
 type mode int
@@ -37,9 +43,28 @@ type model struct {
 	storage      *storage.Storage
 	selectedFile string
 	width        int
+	height       int
+	// Files that changed on disk are sent here.
+	changes <-chan []string
+	// The size and modification time of files right after gotes wrote to them,
+	// so the changes reported for gotes's own writes are ignored.
+	written map[string]fileState
+	// Searched by the list. Kept up to date instead of being rebuilt.
+	index *search.Index
+	// The latest revision of the entry being edited when editing started.
+	editBase storage.Entry
+	// Shown below the current view until the next key press.
+	err error
+	// Edit entries in $VISUAL or $EDITOR instead of the built-in editor.
+	externalEditor bool
 }
 
+var errorStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("196")).Margin(0, 2)
+
 func (model model) Init() tea.Cmd {
+	if model.changes != nil {
+		return gotescmd.WaitForChanges(model.changes)
+	}
 	return nil
 }
 
@@ -48,26 +73,46 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 
 	switch msg := msg.(type) {
 	case tea.WindowSizeMsg:
-		m.width = msg.Width
-		width := msg.Width - mainStyle.GetWidth()
-		height := msg.Height
-		m.list.SetSize(width, height)
-		if m.width >= 100 {
-			m.list.SetSize(min(40, int(0.3*float64(m.width))), height)
-			width -= lipgloss.Width(m.list.View())
+		m.width, m.height = msg.Width, msg.Height
+		m.resize()
+	case tea.KeyMsg:
+		if m.err != nil {
+			m.setErr(nil)
 		}
-		m.viewer.SetHeight(height - 2)
-		m.viewer.SetWidth(width)
-		m.editor.SetHeight(height)
-		m.editor.SetWidth(width)
+	case gotescmd.FilesChangedMsg:
+		m.reload(msg.GetFiles())
+		return m, gotescmd.WaitForChanges(m.changes)
 	case gotescmd.BackMsg:
 		m.mode = browsing
 		return m, nil
 	case gotescmd.NewEntryMsg:
-		m.newEntry(msg.GetEntry())
-		return m, gotescmd.ViewEntry(msg.GetEntry())
+		entry, err := m.newEntry(msg.GetEntry())
+		if err != nil {
+			// Stay where we are so nothing is lost.
+			m.setErr(fmt.Errorf("Couldn't save entry: %w", err))
+			return m, nil
+		}
+		if m.mode == editing {
+			m.editor.RemoveDraft()
+		}
+		if entry.IsHidden() {
+			// Archived and deleted entries leave the list, so go back to it.
+			m.mode = browsing
+			if i := m.list.SelectedItem(); i != nil {
+				m.viewEntry(i.Entry())
+			}
+			return m, nil
+		}
+		return m, gotescmd.ViewEntry(entry)
+	case gotescmd.ErrorMsg:
+		m.setErr(msg.GetError())
+		return m, nil
 	case gotescmd.EditEntryMsg:
+		if m.externalEditor {
+			return m, gotescmd.EditEntryExternally(msg.GetEntry())
+		}
 		m.editor.SetEntry(msg.GetEntry())
+		m.editBase, _ = m.storage.GetLatest(msg.GetEntry().Id())
 		m.mode = editing
 		return m, nil
 	case gotescmd.ViewEntryMsg:
@@ -88,17 +133,99 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 
 	l, cmd := m.list.Update(msg)
 	m.list = l.(list.Model)
-	m.viewEntry(m.list.SelectedItem().Entry())
+	if i := m.list.SelectedItem(); i != nil {
+		m.viewEntry(i.Entry())
+	}
 	return m, tea.Batch(cmd, vcmd)
 }
 
-func (m model) newEntry(entry storage.Entry) {
-	writeEntry(entry)
-	m.storage.AddEntry(entry)
+func (m *model) resize() {
+	width := m.width - mainStyle.GetWidth()
+	height := m.height - lipgloss.Height(m.errView())
+	m.list.SetSize(width, height)
+	if m.width >= 100 {
+		m.list.SetSize(min(40, int(0.3*float64(m.width))), height)
+		width -= lipgloss.Width(m.list.View())
+	}
+	m.viewer.SetHeight(height - 2)
+	m.viewer.SetWidth(width)
+	m.editor.SetHeight(height)
+	m.editor.SetWidth(width)
+}
+
+func (m *model) setErr(err error) {
+	m.err = err
+	m.resize()
+}
+
+// fileState is what's compared to tell whether a file changed.
+type fileState struct {
+	size    int64
+	modTime time.Time
+}
+
+func stat(file string) (fileState, bool) {
+	info, err := os.Stat(file)
+	if err != nil {
+		return fileState{}, false
+	}
+	return fileState{size: info.Size(), modTime: info.ModTime()}, true
+}
+
+// reload reads files that changed on disk again, keeping the current selection.
+func (m *model) reload(files []string) {
+	files = lo.Filter(files, func(file string, index int) bool {
+		state, ok := stat(file)
+		written := m.written[file]
+		return !ok || state.size != written.size || !state.modTime.Equal(written.modTime)
+	})
+	if len(files) == 0 {
+		return
+	}
+
+	for _, file := range files {
+		if err := m.storage.Reload(file); err != nil {
+			m.setErr(err)
+		}
+	}
+	m.viewer.ClearCache()
+
+	m.index.Sync(m.storage.GetAllLatestEntries())
+
+	var selected string
+	if i := m.list.SelectedItem(); i != nil {
+		selected = i.Entry().Id()
+	}
+	if latest, ok := m.storage.GetLatest(m.viewer.EntryId()); ok {
+		m.viewEntry(latest)
+	} else {
+		m.SetItems()
+	}
+	m.list.Select(selected)
+
+	if m.mode == editing && m.editBase.Id() != "" {
+		latest, ok := m.storage.GetLatest(m.editBase.Id())
+		if !ok || latest.Text() != m.editBase.Text() {
+			m.editor.SetWarning("This entry changed on disk since you started editing it.")
+		}
+	}
+}
+
+func (m *model) newEntry(entry storage.Entry) (storage.Entry, error) {
+	entry, err := m.storage.Write(entry)
+	if err != nil {
+		return storage.Entry{}, err
+	}
+	if state, ok := stat(entry.File()); ok {
+		m.written[entry.File()] = state
+	}
+	m.viewer.ClearCache()
+	m.index.Sync(m.storage.GetAllLatestEntries())
 	items := latestEntriesAsItems(m.storage)
 	m.list.SetItems(lo.Filter(items, func(item *list.Item, index int) bool {
-		return item.File() == os.Args[1]
+		return item.File() == m.selectedFile
 	}))
+	return entry, nil
 }
 
 func (m *model) viewEntry(entry storage.Entry) {
@@ -134,9 +261,20 @@ func (m model) View() string {
 		view = m.viewerView()
 	}
 
+	if m.err != nil {
+		view = lipgloss.JoinVertical(lipgloss.Left, view, m.errView())
+	}
+
 	return view
 }
 
+func (m model) errView() string {
+	if m.err == nil {
+		return ""
+	}
+	return errorStyle.Render(m.err.Error())
+}
+
 func (m model) listView() string {
 	if m.width < 100 {
 		return m.list.View()
@@ -153,18 +291,6 @@ func (m model) viewerView() string {
 	return m.listView()
 }
 
-func writeEntry(entry storage.Entry) {
-	f, err := os.OpenFile(entry.File(), os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0600)
-	if err != nil {
-		panic(err)
-	}
-
-	defer f.Close()
-
-	f.WriteString("\n---\n")
-	f.WriteString(entry.String())
-}
-
 func (m *model) SetItems() {
 	items := latestEntriesAsItems(m.storage)
 	items = lo.Filter(items, func(item *list.Item, index int) bool {
@@ -177,34 +303,96 @@ func (m *model) SetItems() {
 
 	m.list.SetItems(items)
 	m.list.SetTitle(m.selectedFile)
+	m.list.SetFile(m.selectedFile)
 }
 
 func main() {
-	store := storage.New(lo.Uniq(os.Args[1:]))
-	verify(store)
+	os.Exit(run(os.Args[1:]))
+}
+
+func tui(files []string, cfg config.Config) int {
+	store, err := storage.New(storage.NewCachedFileBackend(files, storage.DefaultCacheDir()))
+	if err != nil {
+		log.Error(err)
+		return exitError
+	}
+	if !verify(store) {
+		return exitError
+	}
+
+	watcher := watch.New(files)
+	defer watcher.Close()
 
 	m := &model{
 		list:    list.New(),
-		editor:  editor.New(),
+		editor:  editor.New(store),
 		viewer:  viewer.New(store),
 		storage: store,
+		changes: watcher.Changes(),
+		written: make(map[string]fileState),
+		index:   newIndex(store),
+
+		externalEditor: cfg.ExternalEditor,
 	}
 
-	m.selectedFile = os.Args[1]
+	m.editor.SetVim(cfg.Vim)
+	if dir := storage.DefaultCacheDir(); dir != "" {
+		m.editor.SetDraftDir(filepath.Join(dir, "drafts"))
+	}
+	m.list.SetViews(cfg.Views)
+	m.selectedFile = files[0]
+	m.list.SetIndex(m.index)
 	m.SetItems()
 
 	p := tea.NewProgram(m, tea.WithAltScreen())
 
 	if _, err := p.Run(); err != nil {
-		panic(err)
+		log.Error(err)
+		return exitError
+	}
+
+	return exitOK
+}
+
+func verify(s *storage.Storage) bool {
+	problems := findProblems(s)
+
+	for _, p := range problems {
+		if p.Warning {
+			log.Warnf("%s:%d: %s", p.File, p.Line, p.Message)
+		} else {
+			log.Errorf("%s:%d: %s", p.File, p.Line, p.Message)
+		}
 	}
+
+	return !hasErrors(problems)
+}
+
+func hasErrors(problems []problem) bool {
+	return lo.ContainsBy(problems, func(p problem) bool {
+		return !p.Warning
+	})
+}
+
+type problem struct {
+	File    string `json:"file"`
+	Line    int    `json:"line"`
+	Id      string `json:"id"`
+	Message string `json:"message"`
+	// Warnings don't fail verification.
+	Warning bool `json:"warning,omitempty"`
 }
 
-func verify(s *storage.Storage) {
-	var unresolved []string
+func findProblems(s *storage.Storage) []problem {
+	problems := []problem{}
+	parser := markdown.NewParser(s)
+	parser.SetMaxDepth(config.MaxIncludeDepth())
 	for _, entry := range s.GetLatestEntries() {
-		_, u := markdown.NewParser(s).Expand(entry.Text())
-		unresolved = append(unresolved, u...)
+		doc := parser.Parse(entry.Text())
+		// Identifiers within included entries are reported on those entries.
+		unresolved := lo.Filter(doc.Unresolved, func(identifier string, index int) bool {
+			return strings.Contains(entry.Text(), identifier)
+		})
 
 		for _, id := range entry.RelatedIds() {
 			_, ok := s.GetLatest(id)
@@ -212,18 +400,70 @@ func verify(s *storage.Storage) {
 				unresolved = append(unresolved, id)
 			}
 		}
-	}
 
-	for _, identifier := range unresolved {
-		log.Errorf("Couldn't resolve identifier %s", identifier)
-	}
-	if len(unresolved) != 0 {
-		os.Exit(1)
+		for _, identifier := range unresolved {
+			problems = append(problems, problem{
+				File:    entry.File(),
+				Line:    entry.LineOf(identifier),
+				Id:      entry.Id(),
+				Message: fmt.Sprintf("Couldn't resolve identifier %s", identifier),
+			})
+		}
+
+		for _, link := range doc.Ambiguous {
+			target, _ := storage.ParseWikiLink(link)
+			problems = append(problems, problem{
+				File:    entry.File(),
+				Line:    entry.LineOf(link),
+				Id:      entry.Id(),
+				Message: fmt.Sprintf("%s could link to any of %s", link, strings.Join(s.ResolveWikiLink(target.Target), ", ")),
+				Warning: true,
+			})
+		}
+
+		for _, cycle := range doc.Cycles {
+			problems = append(problems, problem{
+				File:    entry.File(),
+				Line:    entry.LineOf(cycle[1]),
+				Id:      entry.Id(),
+				Message: fmt.Sprintf("Include cycle %s", cycle),
+			})
+		}
+
+		if line, ok := entry.UnterminatedFence(); ok {
+			problems = append(problems, problem{
+				File:    entry.File(),
+				Line:    line,
+				Id:      entry.Id(),
+				Message: "Unterminated code fence",
+				Warning: true,
+			})
+		}
+
+		outgoing, _ := s.Relations(entry.Id())
+		for _, edge := range outgoing {
+			if to, ok := s.GetLatest(edge.To); ok && to.IsDeleted() && edge.Reason != storage.RelatedRegexp {
+				problems = append(problems, problem{
+					File:    entry.File(),
+					Line:    entry.LineOf(edge.Detail),
+					Id:      entry.Id(),
+					Message: fmt.Sprintf("Links to deleted entry %s", edge.To),
+					Warning: true,
+				})
+			}
+		}
 	}
+
+	return problems
+}
+
+// newIndex indexes archived and deleted entries too, for when the list shows them.
+func newIndex(s *storage.Storage) *search.Index {
+	return search.NewFromEntries(s.GetAllLatestEntries())
 }
 
 func latestEntriesAsItems(s *storage.Storage) []*list.Item {
-	return lo.Map(s.GetLatestEntries(), func(entry storage.Entry, index int) *list.Item {
+	return lo.Map(s.GetAllLatestEntries(), func(entry storage.Entry, index int) *list.Item {
 		return list.EntryToItem(s, entry)
 	})
 }
@@ -0,0 +1,49 @@
+package markdown
+
+import (
+	"github.com/yuin/goldmark/ast"
+)
+
+// KindMetadata is the kind of Metadata nodes.
+var KindMetadata = ast.NewNodeKind("Metadata")
+
+// Metadata is a line like [_metadata_:key]:# "value". It isn't rendered.
+type Metadata struct {
+	ast.BaseBlock
+	Key   string
+	Value string
+}
+
+func NewMetadata(key string, value string) *Metadata {
+	return &Metadata{Key: key, Value: value}
+}
+
+func (n *Metadata) Kind() ast.NodeKind {
+	return KindMetadata
+}
+
+func (n *Metadata) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, map[string]string{"Key": n.Key, "Value": n.Value}, nil)
+}
+
+// KindInclude is the kind of Include nodes.
+var KindInclude = ast.NewNodeKind("Include")
+
+// Include holds the parsed text of an included entry or file.
+type Include struct {
+	ast.BaseBlock
+	// As it was written, e.g. $id#L10-L20.
+	Identifier string
+}
+
+func NewInclude(identifier string) *Include {
+	return &Include{Identifier: identifier}
+}
+
+func (n *Include) Kind() ast.NodeKind {
+	return KindInclude
+}
+
+func (n *Include) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, map[string]string{"Identifier": n.Identifier}, nil)
+}
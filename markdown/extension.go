@@ -0,0 +1,180 @@
+package markdown
+
+import (
+	"github.com/TotallyNotLost/gotes/storage"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+	"html/template"
+	"regexp"
+)
+
+var (
+	metadataPattern  = regexp.MustCompile(`^ {0,3}\[_metadata_:([^\]]+)\]:# "([^"]*)"[ \t]*\r?\n?$`)
+	shortLinkPattern = regexp.MustCompile(`^\{\$([-0-9a-zA-Z]+)\}`)
+	wikiLinkPattern  = regexp.MustCompile(`^\[\[[^\[\]\n]+\]\]`)
+)
+
+// Extension adds metadata lines, links ({$id}, [[Title]] and [_metadata_:link]:# "$id")
+// and includes ([_metadata_:include]:# "$id") to goldmark.
+//
+// Links and includes are only resolved when parsed by a Parser.
+var Extension goldmark.Extender = &extender{}
+
+type extender struct{}
+
+func (x *extender) Extend(m goldmark.Markdown) {
+	m.Parser().AddOptions(
+		parser.WithBlockParsers(util.Prioritized(&metadataParser{}, 950)),
+		parser.WithInlineParsers(
+			util.Prioritized(&shortLinkParser{}, 900),
+			// Before links, which also start with [.
+			util.Prioritized(&wikiLinkParser{}, 100),
+		),
+		parser.WithASTTransformers(util.Prioritized(&linkTransformer{}, 100)),
+	)
+	m.Renderer().AddOptions(
+		renderer.WithNodeRenderers(util.Prioritized(&htmlRenderer{}, 100)),
+	)
+}
+
+// metadataParser parses metadata lines, which would otherwise be link reference definitions.
+// Like other blocks, they are left alone within code.
+type metadataParser struct{}
+
+func (b *metadataParser) Trigger() []byte {
+	return []byte{'['}
+}
+
+func (b *metadataParser) Open(parent ast.Node, reader text.Reader, pc parser.Context) (ast.Node, parser.State) {
+	line, segment := reader.PeekLine()
+	m := metadataPattern.FindSubmatch(line)
+	if m == nil {
+		return nil, parser.NoChildren
+	}
+	reader.Advance(segment.Len() - 1)
+	return NewMetadata(string(m[1]), string(m[2])), parser.NoChildren
+}
+
+func (b *metadataParser) Continue(node ast.Node, reader text.Reader, pc parser.Context) parser.State {
+	return parser.Close
+}
+
+func (b *metadataParser) Close(node ast.Node, reader text.Reader, pc parser.Context) {}
+
+func (b *metadataParser) CanInterruptParagraph() bool {
+	return true
+}
+
+func (b *metadataParser) CanAcceptIndentedLine() bool {
+	return false
+}
+
+// shortLinkParser parses {$id} links.
+type shortLinkParser struct{}
+
+func (s *shortLinkParser) Trigger() []byte {
+	return []byte{'{'}
+}
+
+func (s *shortLinkParser) Parse(parent ast.Node, block text.Reader, pc parser.Context) ast.Node {
+	line, _ := block.PeekLine()
+	m := shortLinkPattern.FindSubmatch(line)
+	if m == nil {
+		return nil
+	}
+
+	e, ok := pc.Get(expansionKey).(*expansion)
+	if !ok {
+		return nil
+	}
+	block.Advance(len(m[0]))
+	if link := e.link("$" + string(m[1])); link != nil {
+		return link
+	}
+	return nil
+}
+
+// wikiLinkParser parses [[Title]] and [[Title|label]] links.
+type wikiLinkParser struct{}
+
+func (w *wikiLinkParser) Trigger() []byte {
+	return []byte{'['}
+}
+
+func (w *wikiLinkParser) Parse(parent ast.Node, block text.Reader, pc parser.Context) ast.Node {
+	line, _ := block.PeekLine()
+	m := wikiLinkPattern.Find(line)
+	if m == nil {
+		return nil
+	}
+	link, ok := storage.ParseWikiLink(string(m))
+	if !ok {
+		return nil
+	}
+
+	e, ok := pc.Get(expansionKey).(*expansion)
+	if !ok {
+		return nil
+	}
+	block.Advance(len(m))
+	return e.wikiLink(link)
+}
+
+// linkTransformer resolves the links and includes of metadata lines.
+type linkTransformer struct{}
+
+func (t *linkTransformer) Transform(doc *ast.Document, reader text.Reader, pc parser.Context) {
+	e, ok := pc.Get(expansionKey).(*expansion)
+	if !ok {
+		return
+	}
+
+	var metadata []*Metadata
+	ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if m, ok := n.(*Metadata); ok && entering {
+			metadata = append(metadata, m)
+		}
+		return ast.WalkContinue, nil
+	})
+
+	for _, m := range metadata {
+		parent := m.Parent()
+		switch m.Key {
+		case "link":
+			if link := e.link(m.Value); link != nil {
+				paragraph := ast.NewParagraph()
+				paragraph.AppendChild(paragraph, link)
+				parent.ReplaceChild(parent, m, paragraph)
+			}
+		case "include":
+			parent.ReplaceChild(parent, m, e.include(m.Value))
+		}
+	}
+}
+
+// htmlRenderer renders includes as divs and leaves out metadata.
+type htmlRenderer struct{}
+
+func (r *htmlRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(KindMetadata, skip)
+	reg.Register(KindInclude, r.renderInclude)
+}
+
+func (r *htmlRenderer) renderInclude(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	if entering {
+		w.WriteString(`<div class="include" data-identifier="`)
+		w.WriteString(template.HTMLEscapeString(node.(*Include).Identifier))
+		w.WriteString("\">\n")
+	} else {
+		w.WriteString("</div>\n")
+	}
+	return ast.WalkContinue, nil
+}
+
+func skip(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	return ast.WalkSkipChildren, nil
+}
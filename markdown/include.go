@@ -0,0 +1,197 @@
+package markdown
+
+import (
+	"fmt"
+	"github.com/TotallyNotLost/gotes/storage"
+	"github.com/samber/lo"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/text"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+)
+
+// Cycle is a chain of includes that ends where it started, e.g. $a → $b → $a.
+type Cycle []string
+
+func (c Cycle) String() string {
+	return strings.Join(c, " → ")
+}
+
+var expansionKey = parser.NewContextKey()
+
+// expansion resolves the links and includes of a document and of everything it includes.
+type expansion struct {
+	parser *Parser
+	// The document followed by the text that was added while resolving it.
+	// Nodes of included text point into it like the document's own nodes do.
+	source     []byte
+	unresolved []string
+	ambiguous  []string
+	cycles     []Cycle
+	// Where the text of each include was added to source, by normalized identifier.
+	// The text of shared fragments is only looked up and added once.
+	segments map[string]text.Segment
+	// The parsed text of each include, by the includes it's within and its normalized identifier.
+	// Shared fragments are only parsed once and copied after that.
+	parsed map[string]ast.Node
+	// The file the text of each include is in, by normalized identifier.
+	files map[string]string
+	// The normalized identifiers of the includes being parsed, outermost first.
+	stack []string
+	// How many includes the text being parsed is within.
+	depth int
+	// The directory that files included by the text being parsed are relative to.
+	dir string
+}
+
+// parse parses the Markdown at segment of the source.
+func (e *expansion) parse(segment text.Segment) ast.Node {
+	reader := text.NewReader(e.source[:segment.Stop])
+	reader.SetPosition(-1, text.NewSegment(segment.Start, segment.Start))
+	reader.AdvanceLine()
+
+	pc := parser.NewContext()
+	pc.Set(expansionKey, e)
+	return e.parser.markdown.Parser().Parse(reader, parser.WithContext(pc))
+}
+
+// add adds s to the source and returns where it is.
+func (e *expansion) add(s string) text.Segment {
+	start := len(e.source)
+	e.source = append(e.source, s...)
+	return text.NewSegment(start, len(e.source))
+}
+
+// text returns a paragraph saying s.
+func (e *expansion) text(s string) ast.Node {
+	paragraph := ast.NewParagraph()
+	paragraph.AppendChild(paragraph, ast.NewTextSegment(e.add(s)))
+	return paragraph
+}
+
+// link returns a link to the entry, titled with its first line.
+// It returns nil for anything other than an entry.
+func (e *expansion) link(value string) *ast.Link {
+	p := e.parser
+	identifier := p.normalizeIdentifier(value)
+	if !strings.HasPrefix(identifier, "$") {
+		return nil
+	}
+
+	id := strings.TrimLeft(parseIdentifier(identifier).source, "$")
+	entry, ok := p.storage.GetLatest(id)
+	if !ok {
+		e.unresolved = append(e.unresolved, identifier)
+	}
+
+	link := ast.NewLink()
+	link.Destination = []byte(identifier)
+	if title := entry.Title(); title != "" {
+		link.AppendChild(link, ast.NewTextSegment(e.add(title)))
+	}
+	return link
+}
+
+// wikiLink returns a link to the entry titled or aliased link.Target.
+// It's plain text when no entry or several entries are.
+func (e *expansion) wikiLink(link storage.WikiLink) ast.Node {
+	label := lo.CoalesceOrEmpty(link.Label, link.Target)
+
+	ids := e.parser.storage.ResolveWikiLink(link.Target)
+	switch len(ids) {
+	case 0:
+		e.unresolved = append(e.unresolved, link.String())
+	case 1:
+		node := ast.NewLink()
+		node.Destination = []byte("$" + ids[0])
+		node.AppendChild(node, ast.NewTextSegment(e.add(label)))
+		return node
+	default:
+		e.ambiguous = append(e.ambiguous, link.String())
+	}
+	return ast.NewTextSegment(e.add(label))
+}
+
+// include returns the parsed text of a single include.
+func (e *expansion) include(incl string) ast.Node {
+	p := e.parser
+	identifier := p.normalizeIdentifier(incl)
+	node := NewInclude(incl)
+
+	if i := slices.Index(e.stack, identifier); i >= 0 {
+		cycle := Cycle(append(slices.Clone(e.stack[i:]), identifier))
+		e.cycles = append(e.cycles, cycle)
+		node.AppendChild(node, e.text("Include cycle "+cycle.String()))
+		return node
+	}
+
+	if e.depth >= p.maxDepth {
+		node.AppendChild(node, e.text(fmt.Sprintf("%s is nested more than %d includes deep", incl, p.maxDepth)))
+		return node
+	}
+
+	// Relative files are different files depending on where they're included from.
+	if !strings.HasPrefix(identifier, "$") && !filepath.IsAbs(identifier) {
+		identifier = filepath.Join(e.dir, identifier)
+	}
+
+	// What the fragment includes in turn depends on what it's within.
+	key := strings.Join(append(slices.Clone(e.stack), identifier), "\x00")
+	doc, ok := e.parsed[key]
+	if ok {
+		doc = clone(doc)
+	} else {
+		segment, ok := e.segments[identifier]
+		if !ok {
+			text, file, found := p.getTextForIdentifier(identifier)
+			if !found {
+				e.unresolved = append(e.unresolved, incl)
+				return node
+			}
+			// Start on a line of its own.
+			e.add("\n")
+			segment = e.add(text)
+			e.segments[identifier] = segment
+			e.files[identifier] = file
+		}
+
+		stack, dir := e.stack, e.dir
+		e.stack = append(slices.Clone(stack), identifier)
+		e.dir = filepath.Dir(e.files[identifier])
+		e.depth++
+		doc = e.parse(segment)
+		e.stack, e.dir = stack, dir
+		e.depth--
+
+		// The children of doc are moved into node below, so a copy is kept.
+		e.parsed[key] = clone(doc)
+	}
+
+	for child := doc.FirstChild(); child != nil; {
+		next := child.NextSibling()
+		node.AppendChild(node, child)
+		child = next
+	}
+	return node
+}
+
+// clone returns a deep copy of n. Apart from their links to each other,
+// nodes only hold values and segments of the source, so copying each node
+// and linking the copies up again is enough.
+func clone(n ast.Node) ast.Node {
+	v := reflect.New(reflect.TypeOf(n).Elem())
+	v.Elem().Set(reflect.ValueOf(n).Elem())
+	v.Elem().FieldByName("BaseNode").Set(reflect.ValueOf(ast.BaseNode{}))
+
+	c := v.Interface().(ast.Node)
+	for _, attribute := range n.Attributes() {
+		c.SetAttribute(attribute.Name, attribute.Value)
+	}
+	for child := n.FirstChild(); child != nil; child = child.NextSibling() {
+		c.AppendChild(c, clone(child))
+	}
+	return c
+}
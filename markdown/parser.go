@@ -3,118 +3,185 @@ package markdown
 import (
 	"fmt"
 	"github.com/TotallyNotLost/gotes/storage"
-	"github.com/charmbracelet/lipgloss"
 	"github.com/samber/lo"
-	"regexp"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/text"
+	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
 )
 
+// How deeply includes are expanded within included text by default.
+const DefaultMaxDepth = 5
+
 func NewParser(storage *storage.Storage) *Parser {
-	return &Parser{storage: storage}
+	md := goldmark.New(
+		goldmark.WithExtensions(
+			extension.GFM,
+			extension.DefinitionList,
+			Extension,
+		),
+		goldmark.WithParserOptions(
+			parser.WithAutoHeadingID(),
+		),
+	)
+	return &Parser{storage: storage, maxDepth: DefaultMaxDepth, markdown: md}
 }
 
 type Parser struct {
-	storage *storage.Storage
+	storage  *storage.Storage
+	maxDepth int
+	markdown goldmark.Markdown
 }
 
-// Returns the expanded string and a list of all the IDs that couldn't be resolved.
-func (p *Parser) Expand(md string) (string, []string) {
-	link, u1 := p.expandLink(p.expandLinkShortSyntax(md))
-	expanded, u2 := p.expandIncludes(link)
-	return expanded, append(u1, u2...)
+// SetMaxDepth sets how deeply includes are nested within included text.
+// Includes nested any deeper are left out. A depth of 0 or less keeps the default.
+func (p *Parser) SetMaxDepth(depth int) {
+	if depth <= 0 {
+		depth = DefaultMaxDepth
+	}
+	p.maxDepth = depth
 }
 
-func (p *Parser) expandLinkShortSyntax(md string) string {
-	r, _ := regexp.Compile("\\{\\$([-0-9a-zA-Z]+)\\}")
-
-	return r.ReplaceAllStringFunc(md, func(metadata string) string {
-		id := r.FindStringSubmatch(metadata)[1]
-
-		return fmt.Sprintf("[_metadata_:link]:# \"$%s\"", id)
-	})
+// Document is parsed Markdown with its links and includes resolved.
+// It can be rendered for the terminal or as HTML.
+type Document struct {
+	Node ast.Node
+	// The Markdown followed by everything added while resolving it,
+	// like the text of includes.
+	Source []byte
+	// The identifiers that couldn't be resolved.
+	Unresolved []string
+	// The wiki links to titles or aliases of several entries.
+	Ambiguous []string
+	// The includes that include themselves.
+	Cycles   []Cycle
+	markdown goldmark.Markdown
 }
 
-func (p *Parser) expandLink(md string) (string, []string) {
-	r, _ := regexp.Compile("\\[_metadata_:link\\]:# \"([^\"]*)\"")
-	var unresolved []string
-
-	expanded := r.ReplaceAllStringFunc(md, func(metadata string) string {
-		identifier := p.normalizeIdentifier(r.FindStringSubmatch(metadata)[1])
-		if strings.HasPrefix(identifier, "$") {
-			id := strings.TrimLeft(identifier, "$")
-			entry, ok := p.storage.GetLatest(id)
-			if !ok {
-				unresolved = append(unresolved, identifier)
-			}
-			text := entry.Text()
-			title := lo.FirstOrEmpty(strings.Split(text, "\n"))
-
-			return fmt.Sprintf("[%s](%s)", title, identifier)
+// Parse parses md, resolving its links and includes.
+// Files are included relative to the notes file of the entry md is a revision of.
+func (p *Parser) Parse(md string) *Document {
+	var file string
+	if id := lo.FirstOrEmpty(storage.GetMetadata(md)["id"]); id != "" {
+		if entry, ok := p.storage.GetLatest(id); ok {
+			file = entry.File()
 		}
-		return ""
-	})
-
-	return expanded, unresolved
+	}
+	return p.ParseIn(md, file)
 }
 
-func (p *Parser) expandIncludes(md string) (string, []string) {
-	r, _ := regexp.Compile("\\[_metadata_:include\\]:# \"([^\"]*)\"")
-	var unresolved []string
+// ParseIn is like Parse but includes files relative to the directory of file,
+// the notes file md is written to.
+func (p *Parser) ParseIn(md string, file string) *Document {
+	e := &expansion{
+		parser:   p,
+		source:   []byte(md),
+		segments: make(map[string]text.Segment),
+		parsed:   make(map[string]ast.Node),
+		files:    make(map[string]string),
+		dir:      filepath.Dir(file),
+	}
 
-	expanded := r.ReplaceAllStringFunc(md, func(metadata string) string {
-		identifier := p.normalizeIdentifier(r.FindStringSubmatch(metadata)[1])
-		text, ok := p.getTextForIdentifier(identifier)
-		if !ok {
-			unresolved = append(unresolved, identifier)
-		}
-		sanitized := strings.TrimSpace(RemoveMetadata(RemoveMetadata(text, "id"), "related"))
+	// An entry including itself through others is a cycle too.
+	if id := lo.FirstOrEmpty(storage.GetMetadata(md)["id"]); id != "" {
+		e.stack = append(e.stack, "$"+id)
+	}
 
-		return lipgloss.NewStyle().Border(lipgloss.NormalBorder()).Render(sanitized)
-	})
+	node := e.parse(text.NewSegment(0, len(md)))
+	return &Document{
+		Node:       node,
+		Source:     e.source,
+		Unresolved: lo.Uniq(e.unresolved),
+		Ambiguous:  lo.Uniq(e.ambiguous),
+		Cycles:     lo.UniqBy(e.cycles, Cycle.String),
+		markdown:   p.markdown,
+	}
+}
 
-	return expanded, unresolved
+// HTML renders the document as HTML.
+func (d *Document) HTML() (string, error) {
+	var sb strings.Builder
+	err := d.markdown.Renderer().Render(&sb, d.Source, d.Node)
+	return sb.String(), err
 }
 
 // Take the include and add optional parts.
 // This will make it easier to parse/handle elsewhere.
 //
+// Includes are either an entry or a file, optionally followed by a selector:
+//
+//	$id                 the latest revision of the entry
+//	$id@rev2            revision 2 of the entry (also @2, @HEAD or @HEAD~1)
+//	$id#L10-L20         lines 10 through 20 of the entry
+//	$id#heading-slug    the section under the heading
+//	file.md:10-20       lines 10 through 20 of the file
+//	file.md:L10-L20     lines 10 through 20 of the file
+//	file.md#heading     the section under the heading in the file
+//
+// Relative file paths are relative to the notes file of the including entry,
+// or to the included file for includes within a file.
+//
 // Normalized format:
-// [selector]
+// source[@revision][selector]
 func (p *Parser) normalizeIdentifier(incl string) string {
-	return p.normalizeInclSelector(incl)
+	id := parseIdentifier(incl)
+	normalized := id.source
+	if id.revision != "" {
+		normalized += "@" + id.revision
+	}
+	return normalized + p.normalizeInclSelector(id.selector)
 }
 
 // Normalizes selector so that it fits one of these formats:
 //
-// 1. <epty> - Return the entire contents of the file.
-// 2. #{id} -> The ID of a note within the file.
-// 3. {start}-{end} -> Line numbers to include. Start is inclusive, end is exclusive.
+// 1. <empty> - Return the entire contents of the file.
+// 2. #{slug} -> The section under a heading within the note.
+// 3. :{start}-{end} -> Line numbers to include. Start is inclusive, end is exclusive.
 func (p *Parser) normalizeInclSelector(selector string) string {
 	if selector == "" {
 		return selector
 	}
 
-	valid, _ := regexp.Compile("^(\\$.+)|(\\d+-\\d+)$")
-
-	if valid.MatchString(selector) {
+	m := linesPattern.FindStringSubmatch(selector)
+	if m == nil {
 		return selector
 	}
 
-	// This appears to be a line number selector.
-	// Expand this to be a line range.
-	// TODO: This should go to selector+1 since end is exclusive.
-	return fmt.Sprintf("%s-%s", selector, selector)
+	// Every range includes its end line.
+	start, _ := strconv.Atoi(m[2])
+	end := start
+	if m[3] != "" {
+		end, _ = strconv.Atoi(m[3])
+	}
+	return fmt.Sprintf(":%d-%d", start, end+1)
 }
 
-func (p *Parser) getTextForIdentifier(identifier string) (string, bool) {
-	if strings.HasPrefix(identifier, "$") {
-		id := strings.TrimLeft(identifier, "$")
-		entry, ok := p.storage.GetLatest(id)
+// getTextForIdentifier returns the text of a normalized identifier and the file it's in.
+func (p *Parser) getTextForIdentifier(identifier string) (string, string, bool) {
+	id := parseIdentifier(identifier)
+
+	var text, file string
+	if strings.HasPrefix(id.source, "$") {
+		rev := lo.Ternary(id.revision == "", "HEAD", id.revision)
+		entry, ok := p.storage.GetRevision(strings.TrimLeft(id.source, "$"), rev)
 		if !ok {
-			return "", false
+			return "", "", false
+		}
+		text, file = entry.Text(), entry.File()
+	} else {
+		file = id.source
+		b, err := os.ReadFile(file)
+		if err != nil || id.revision != "" {
+			return "", "", false
 		}
-		return entry.Text(), true
+		text = string(b)
 	}
 
-	return identifier, true
+	text, ok := selectText(text, id.selector)
+	return text, file, ok
 }
@@ -0,0 +1,106 @@
+package markdown
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+var (
+	// An entry, optionally at a revision, followed by a selector.
+	entryIdentifierPattern = regexp.MustCompile(`^(\$[-0-9a-zA-Z]+)(?:@(?:rev)?([^#:]+))?([#:].*)?$`)
+	// A file followed by a line range.
+	fileLinesPattern = regexp.MustCompile(`^(.+?)(:L?\d+(?:-L?\d+)?)$`)
+	// Line ranges: #L10-L20, #L10, :L10-L20, :10-20 or :10.
+	linesPattern   = regexp.MustCompile(`^(#L|:L|:)(\d+)(?:-L?(\d+))?$`)
+	headingPattern = regexp.MustCompile(`^ {0,3}(#{1,6})[ \t]+(.*?)[ \t#]*$`)
+	fencePattern   = regexp.MustCompile("^ {0,3}(```|~~~)")
+)
+
+type identifier struct {
+	// $id or a file path.
+	source   string
+	revision string
+	// Starts with # or :, if there is one.
+	selector string
+}
+
+func parseIdentifier(incl string) identifier {
+	if m := entryIdentifierPattern.FindStringSubmatch(incl); m != nil {
+		return identifier{source: m[1], revision: m[2], selector: m[3]}
+	}
+	if m := fileLinesPattern.FindStringSubmatch(incl); m != nil {
+		return identifier{source: m[1], selector: m[2]}
+	}
+	if i := strings.LastIndex(incl, "#"); i > 0 {
+		return identifier{source: incl[:i], selector: incl[i:]}
+	}
+	return identifier{source: incl}
+}
+
+// selectText returns the part of text chosen by a normalized selector.
+func selectText(text string, selector string) (string, bool) {
+	lines := strings.Split(text, "\n")
+
+	switch {
+	case selector == "":
+		return text, true
+	case strings.HasPrefix(selector, ":"):
+		var start, end int
+		if _, err := fmt.Sscanf(selector, ":%d-%d", &start, &end); err != nil {
+			return "", false
+		}
+		if start < 1 || end <= start || start > len(lines) {
+			return "", false
+		}
+		return strings.Join(lines[start-1:min(end-1, len(lines))], "\n"), true
+	case strings.HasPrefix(selector, "#"):
+		return section(lines, strings.TrimPrefix(selector, "#"))
+	}
+
+	return "", false
+}
+
+// section returns the heading whose slug matches and everything under it,
+// up to the next heading of the same or a higher level.
+func section(lines []string, anchor string) (string, bool) {
+	start, level := -1, 0
+	fenced := false
+
+	for i, line := range lines {
+		if fencePattern.MatchString(line) {
+			fenced = !fenced
+		}
+		m := headingPattern.FindStringSubmatch(line)
+		if fenced || m == nil {
+			continue
+		}
+
+		if start >= 0 && len(m[1]) <= level {
+			return strings.Join(lines[start:i], "\n"), true
+		}
+		if start < 0 && slug(m[2]) == anchor {
+			start, level = i, len(m[1])
+		}
+	}
+
+	if start < 0 {
+		return "", false
+	}
+	return strings.Join(lines[start:], "\n"), true
+}
+
+// slug returns the anchor of a heading the way GitHub generates it.
+func slug(heading string) string {
+	var sb strings.Builder
+	for _, r := range strings.ToLower(strings.TrimSpace(heading)) {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r) || r == '-' || r == '_':
+			sb.WriteRune(r)
+		case r == ' ':
+			sb.WriteRune('-')
+		}
+	}
+	return sb.String()
+}
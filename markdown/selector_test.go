@@ -0,0 +1,149 @@
+package markdown
+
+import (
+	"github.com/TotallyNotLost/gotes/storage"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseIdentifier(t *testing.T) {
+	tests := []struct {
+		incl string
+		want identifier
+	}{
+		{"$abc", identifier{source: "$abc"}},
+		{"$abc@rev2", identifier{source: "$abc", revision: "2"}},
+		{"$abc@HEAD~1", identifier{source: "$abc", revision: "HEAD~1"}},
+		{"$abc#L10-L20", identifier{source: "$abc", selector: "#L10-L20"}},
+		{"$abc@3:10-20", identifier{source: "$abc", revision: "3", selector: ":10-20"}},
+		{"$abc#some-heading", identifier{source: "$abc", selector: "#some-heading"}},
+		{"notes.md", identifier{source: "notes.md"}},
+		{"notes.md:10-20", identifier{source: "notes.md", selector: ":10-20"}},
+		{"notes.md:L10-L20", identifier{source: "notes.md", selector: ":L10-L20"}},
+		{"notes.md:10", identifier{source: "notes.md", selector: ":10"}},
+		{"dir/notes.md#heading", identifier{source: "dir/notes.md", selector: "#heading"}},
+	}
+
+	for _, tt := range tests {
+		if got := parseIdentifier(tt.incl); got != tt.want {
+			t.Errorf("parseIdentifier(%q) = %+v, want %+v", tt.incl, got, tt.want)
+		}
+	}
+}
+
+func TestNormalizeInclSelector(t *testing.T) {
+	tests := []struct {
+		selector string
+		want     string
+	}{
+		{"", ""},
+		{":10-20", ":10-21"},
+		{":10-L20", ":10-21"},
+		{":10", ":10-11"},
+		{"#L10-L20", ":10-21"},
+		{"#L10-20", ":10-21"},
+		{"#L10", ":10-11"},
+		{":L10-L20", ":10-21"},
+		{":L10", ":10-11"},
+		{"#heading", "#heading"},
+		{"#10", "#10"},
+	}
+
+	var p Parser
+	for _, tt := range tests {
+		if got := p.normalizeInclSelector(tt.selector); got != tt.want {
+			t.Errorf("normalizeInclSelector(%q) = %q, want %q", tt.selector, got, tt.want)
+		}
+	}
+}
+
+func TestSelectText(t *testing.T) {
+	text := "# Title\nintro\n## First Part\none\n```\n# not a heading\n```\n## Second_part!\ntwo\n# Next\nlast"
+
+	tests := []struct {
+		selector string
+		want     string
+		ok       bool
+	}{
+		{"", text, true},
+		{":2-3", "intro", true},
+		{":1-3", "# Title\nintro", true},
+		{":10-100", "# Next\nlast", true},
+		{":3-3", "", false},
+		{":0-2", "", false},
+		{":20-30", "", false},
+		{":abc", "", false},
+		{"#first-part", "## First Part\none\n```\n# not a heading\n```", true},
+		{"#second_part", "## Second_part!\ntwo", true},
+		{"#title", "# Title\nintro\n## First Part\none\n```\n# not a heading\n```\n## Second_part!\ntwo", true},
+		{"#next", "# Next\nlast", true},
+		{"#not-a-heading", "", false},
+		{"#missing", "", false},
+	}
+
+	for _, tt := range tests {
+		got, ok := selectText(text, tt.selector)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("selectText(%q) = %q, %v, want %q, %v", tt.selector, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestIncludeFile(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string, text string) {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(text), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("notes/lines.md", "one\n\ntwo\n\nthree\n\nfour")
+	// Files included by a file are relative to it.
+	write("notes/parts/outer.md", "outer\n\n[_metadata_:include]:# \"inner.md\"")
+	write("notes/parts/inner.md", "inner")
+
+	tests := []struct {
+		include string
+		want    []string
+		missing []string
+	}{
+		{"lines.md:3-5", []string{"two", "three"}, []string{"one", "four"}},
+		{"lines.md:L3-L5", []string{"two", "three"}, []string{"one", "four"}},
+		{"lines.md#L3-L5", []string{"two", "three"}, []string{"one", "four"}},
+		{"lines.md:3", []string{"two"}, []string{"one", "three"}},
+		{"parts/outer.md", []string{"outer", "inner"}, nil},
+	}
+
+	for _, tt := range tests {
+		text := "# Entry\n[_metadata_:id]:# \"abc\"\n[_metadata_:include]:# \"" + tt.include + "\""
+		entry := storage.NewEntry(filepath.Join(dir, "notes", "notes.md"), text, 0, 0, 0)
+		s, err := storage.New(storage.NewMemoryBackend(entry))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		doc := NewParser(s).Parse(text)
+		html, err := doc.HTML()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(doc.Unresolved) > 0 {
+			t.Errorf("%s: couldn't resolve %q", tt.include, doc.Unresolved)
+		}
+		for _, want := range tt.want {
+			if !strings.Contains(html, want) {
+				t.Errorf("%s: %q doesn't include %q", tt.include, html, want)
+			}
+		}
+		for _, missing := range tt.missing {
+			if strings.Contains(html, missing) {
+				t.Errorf("%s: %q includes %q", tt.include, html, missing)
+			}
+		}
+	}
+}
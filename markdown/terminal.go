@@ -0,0 +1,56 @@
+package markdown
+
+import (
+	"github.com/charmbracelet/glamour/ansi"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/util"
+	"strings"
+)
+
+// TerminalRenderer renders documents for the terminal the way glamour does.
+type TerminalRenderer struct {
+	renderer renderer.Renderer
+}
+
+func NewTerminalRenderer(options ansi.Options) *TerminalRenderer {
+	ar := ansi.NewRenderer(options)
+	return &TerminalRenderer{
+		renderer: renderer.NewRenderer(
+			renderer.WithNodeRenderers(
+				util.Prioritized(ar, 1000),
+				util.Prioritized(&terminalRenderer{ansi: ar}, 100),
+			),
+		),
+	}
+}
+
+func (r *TerminalRenderer) Render(d *Document) (string, error) {
+	var sb strings.Builder
+	err := r.renderer.Render(&sb, d.Source, d.Node)
+	return sb.String(), err
+}
+
+// terminalRenderer renders includes like block quotes and leaves out metadata.
+type terminalRenderer struct {
+	ansi *ansi.ANSIRenderer
+}
+
+func (r *terminalRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	funcs := registry{}
+	r.ansi.RegisterFuncs(funcs)
+
+	// glamour only knows its own kinds, so it's given a block quote in place of the include.
+	blockquote := funcs[ast.KindBlockquote]
+	reg.Register(KindInclude, func(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+		return blockquote(w, source, ast.NewBlockquote(), entering)
+	})
+	reg.Register(KindMetadata, skip)
+}
+
+// registry records the functions a renderer registers.
+type registry map[ast.NodeKind]renderer.NodeRendererFunc
+
+func (r registry) Register(kind ast.NodeKind, f renderer.NodeRendererFunc) {
+	r[kind] = f
+}
@@ -0,0 +1,267 @@
+package query
+
+import (
+	"fmt"
+	"github.com/TotallyNotLost/gotes/storage"
+	"github.com/samber/lo"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Query selects entries by their metadata and text.
+//
+// A query is a list of terms separated by whitespace, all of which must match.
+// Terms are either a bare word, which matches the entry's text,
+// or key:value where key is one of:
+//
+//	tag:#Done              the entry is related to the id #Done
+//	related:id=abc         the entry has the related metadata "id=abc"
+//	id:abc                 the entry's id is abc
+//	file:work.md           the entry's file, or its base name, matches the glob
+//	text:deploy            the entry's text contains deploy (case-insensitive)
+//	meta:key=value         the entry has metadata key with value (or any value if omitted)
+//	updated:>2026-01-01    the entry was last updated after the date
+//	created:<=2026-01-01   the entry was created on or before the date
+//
+// Dates may be compared with <, <=, =, >= and >, by calendar day. Values containing spaces
+// must be quoted, e.g. tag:"#Project X". Prefix a term with - to negate it.
+type Query struct {
+	source string
+	terms  []term
+}
+
+type term struct {
+	negate bool
+	key    string
+	value  string
+	// Only used for date terms.
+	op   string
+	date time.Time
+}
+
+// Error is returned when a query can't be parsed.
+type Error struct {
+	Query  string
+	Offset int
+	Msg    string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("query: %s at offset %d", e.Msg, e.Offset)
+}
+
+var keys = []string{"tag", "related", "id", "file", "text", "meta", "updated", "created"}
+
+var dateLayouts = []string{time.RFC3339, "2006-01-02T15:04", "2006-01-02"}
+
+func Parse(s string) (Query, error) {
+	q := Query{source: s}
+	p := parser{s: s}
+
+	for {
+		p.skipSpace()
+		if p.done() {
+			return q, nil
+		}
+
+		start := p.pos
+		t := term{key: "text"}
+		if p.peek() == '-' {
+			t.negate = true
+			p.pos++
+		}
+
+		word, quoted, err := p.word()
+		if err != nil {
+			return Query{}, err
+		}
+
+		if key, value, found := strings.Cut(word, ":"); found && !quoted {
+			if !slices.Contains(keys, key) {
+				return Query{}, &Error{Query: s, Offset: start, Msg: fmt.Sprintf("unknown key %q", key)}
+			}
+			t.key, t.value = key, value
+		} else {
+			t.value = word
+		}
+
+		if t.key == "updated" || t.key == "created" {
+			if err := t.parseDate(); err != nil {
+				return Query{}, &Error{Query: s, Offset: start, Msg: err.Error()}
+			}
+		}
+
+		if t.value == "" {
+			return Query{}, &Error{Query: s, Offset: start, Msg: "empty term"}
+		}
+
+		q.terms = append(q.terms, t)
+	}
+}
+
+// MustParse is like Parse but panics if the query can't be parsed.
+func MustParse(s string) Query {
+	q, err := Parse(s)
+	if err != nil {
+		panic(err)
+	}
+	return q
+}
+
+func (t *term) parseDate() error {
+	value := t.value
+	t.op = "="
+	for _, op := range []string{">=", "<=", ">", "<", "="} {
+		if v, found := strings.CutPrefix(value, op); found {
+			t.op, value = op, v
+			break
+		}
+	}
+
+	for _, layout := range dateLayouts {
+		if d, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			t.date = d
+			return nil
+		}
+	}
+
+	return fmt.Errorf("invalid date %q", value)
+}
+
+type parser struct {
+	s   string
+	pos int
+}
+
+func (p *parser) done() bool {
+	return p.pos >= len(p.s)
+}
+
+func (p *parser) peek() byte {
+	if p.done() {
+		return 0
+	}
+	return p.s[p.pos]
+}
+
+func (p *parser) skipSpace() {
+	for !p.done() && (p.peek() == ' ' || p.peek() == '\t') {
+		p.pos++
+	}
+}
+
+// word reads up to the next unquoted space.
+// quoted is true if the whole word was quoted.
+func (p *parser) word() (string, bool, error) {
+	var sb strings.Builder
+	quoted := p.peek() == '"'
+
+	for !p.done() && p.peek() != ' ' && p.peek() != '\t' {
+		if p.peek() != '"' {
+			sb.WriteByte(p.peek())
+			p.pos++
+			continue
+		}
+
+		start := p.pos
+		end := strings.IndexByte(p.s[p.pos+1:], '"')
+		if end < 0 {
+			return "", false, &Error{Query: p.s, Offset: start, Msg: "unterminated quote"}
+		}
+		sb.WriteString(p.s[p.pos+1 : p.pos+1+end])
+		p.pos += end + 2
+	}
+
+	return sb.String(), quoted, nil
+}
+
+func (q Query) String() string {
+	return q.source
+}
+
+// Empty returns whether the query has no terms, in which case it matches everything.
+func (q Query) Empty() bool {
+	return len(q.terms) == 0
+}
+
+// Match returns whether e matches every term of the query.
+func (q Query) Match(e storage.Entry) bool {
+	var metadata map[string][]string
+	for _, t := range q.terms {
+		if metadata == nil && t.needsMetadata() {
+			metadata = e.Metadata()
+		}
+		if t.match(e, metadata) == t.negate {
+			return false
+		}
+	}
+	return true
+}
+
+// Filter returns the entries matching the query.
+func (q Query) Filter(entries []storage.Entry) []storage.Entry {
+	return lo.Filter(entries, func(e storage.Entry, index int) bool {
+		return q.Match(e)
+	})
+}
+
+func (t term) needsMetadata() bool {
+	return t.key == "related" || t.key == "meta"
+}
+
+func (t term) match(e storage.Entry, metadata map[string][]string) bool {
+	switch t.key {
+	case "tag":
+		return slices.Contains(e.RelatedIds(), t.value)
+	case "related":
+		return slices.Contains(metadata["related"], t.value)
+	case "id":
+		return e.Id() == t.value
+	case "file":
+		for _, name := range []string{e.File(), filepath.Base(e.File())} {
+			if ok, _ := filepath.Match(t.value, name); ok || name == t.value {
+				return true
+			}
+		}
+		return false
+	case "text":
+		return strings.Contains(strings.ToLower(e.Text()), strings.ToLower(t.value))
+	case "meta":
+		key, value, hasValue := strings.Cut(t.value, "=")
+		values, ok := metadata[key]
+		return ok && (!hasValue || slices.Contains(values, value))
+	case "updated":
+		updated, ok := e.Updated()
+		return ok && t.matchDate(updated)
+	case "created":
+		created, ok := e.Created()
+		return ok && t.matchDate(created)
+	}
+	return false
+}
+
+// matchDate compares the calendar days of date and the term's date,
+// so created>2024-01-01 means from January 2nd on.
+func (t term) matchDate(date time.Time) bool {
+	date, target := day(date), day(t.date)
+
+	switch t.op {
+	case ">":
+		return date.After(target)
+	case ">=":
+		return !date.Before(target)
+	case "<":
+		return date.Before(target)
+	case "<=":
+		return !date.After(target)
+	}
+	return date.Equal(target)
+}
+
+// day returns the start of the local calendar day that t is on.
+func day(t time.Time) time.Time {
+	y, m, d := t.In(time.Local).Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.Local)
+}
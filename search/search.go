@@ -0,0 +1,384 @@
+package search
+
+import (
+	"github.com/TotallyNotLost/gotes/storage"
+	"math"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// BM25 parameters.
+const (
+	k1 = 1.2
+	b  = 0.75
+)
+
+// Index is an inverted index over the text of entries.
+type Index struct {
+	docs     []document
+	postings map[string][]posting
+	// Number of tokens in all documents.
+	total int
+	// Average number of tokens per document.
+	avgLength float64
+}
+
+type document struct {
+	entry  storage.Entry
+	tokens []token
+}
+
+type posting struct {
+	doc int
+	// Positions of the term within the document's tokens.
+	positions []int
+}
+
+type token struct {
+	term string
+	// Byte offsets of the token within the entry's text.
+	start int
+	end   int
+}
+
+// Result is an entry matching a query.
+type Result struct {
+	Entry storage.Entry
+	Score float64
+	// Byte ranges of the text that matched the query.
+	Matches [][2]int
+	doc     int
+}
+
+// New indexes the latest revision of every entry in s.
+// If revisions is true older revisions are indexed too.
+func New(s *storage.Storage, revisions bool) *Index {
+	entries := s.GetLatestEntries()
+	if revisions {
+		entries = []storage.Entry{}
+		for _, latest := range s.GetLatestEntries() {
+			all, _ := s.Get(latest.Id())
+			entries = append(entries, all...)
+		}
+	}
+
+	return NewFromEntries(entries)
+}
+
+// NewFromEntries indexes the given entries.
+func NewFromEntries(entries []storage.Entry) *Index {
+	idx := &Index{postings: make(map[string][]posting)}
+
+	for _, entry := range entries {
+		idx.add(entry)
+	}
+	idx.updateAvgLength()
+
+	return idx
+}
+
+// Sync updates an index of the latest revisions to entries. Only entries whose text
+// changed are indexed again, and entries that aren't in entries anymore are removed.
+func (idx *Index) Sync(entries []storage.Entry) {
+	latest := make(map[string]storage.Entry, len(entries))
+	for _, e := range entries {
+		latest[e.Id()] = e
+	}
+
+	// Backwards, since remove moves the last document into the removed one's place.
+	for i := len(idx.docs) - 1; i >= 0; i-- {
+		old := idx.docs[i].entry
+		e, ok := latest[old.Id()]
+		if !ok || e.Text() != old.Text() {
+			idx.remove(i)
+			continue
+		}
+		// The text is the same but the entry may have moved within its file.
+		idx.docs[i].entry = e
+		delete(latest, e.Id())
+	}
+
+	for _, e := range entries {
+		if _, ok := latest[e.Id()]; ok {
+			idx.add(e)
+		}
+	}
+	idx.updateAvgLength()
+}
+
+// add indexes entry as the last document.
+func (idx *Index) add(entry storage.Entry) {
+	doc := len(idx.docs)
+	tokens := tokenize(withoutMetadata(entry.Text()))
+	idx.docs = append(idx.docs, document{entry: entry, tokens: tokens})
+	idx.total += len(tokens)
+
+	for term, p := range positions(tokens) {
+		idx.postings[term] = append(idx.postings[term], posting{doc: doc, positions: p})
+	}
+}
+
+// remove removes document doc, moving the last document into its place.
+func (idx *Index) remove(doc int) {
+	removed := idx.docs[doc]
+	idx.total -= len(removed.tokens)
+	for term := range positions(removed.tokens) {
+		idx.postings[term] = slices.DeleteFunc(idx.postings[term], func(p posting) bool { return p.doc == doc })
+		if len(idx.postings[term]) == 0 {
+			delete(idx.postings, term)
+		}
+	}
+
+	last := len(idx.docs) - 1
+	if doc != last {
+		idx.docs[doc] = idx.docs[last]
+		for term := range positions(idx.docs[doc].tokens) {
+			for i, p := range idx.postings[term] {
+				if p.doc == last {
+					idx.postings[term][i].doc = doc
+				}
+			}
+		}
+	}
+	idx.docs = idx.docs[:last]
+}
+
+func (idx *Index) updateAvgLength() {
+	idx.avgLength = 0
+	if len(idx.docs) > 0 {
+		idx.avgLength = float64(idx.total) / float64(len(idx.docs))
+	}
+}
+
+// positions returns the positions of each term in tokens.
+func positions(tokens []token) map[string][]int {
+	positions := make(map[string][]int)
+	for pos, t := range tokens {
+		positions[t.term] = append(positions[t.term], pos)
+	}
+	return positions
+}
+
+func tokenize(text string) []token {
+	tokens := []token{}
+	start := -1
+
+	for i, r := range text {
+		isWord := unicode.IsLetter(r) || unicode.IsDigit(r)
+		if isWord && start < 0 {
+			start = i
+		} else if !isWord && start >= 0 {
+			tokens = append(tokens, token{term: strings.ToLower(text[start:i]), start: start, end: i})
+			start = -1
+		}
+	}
+
+	if start >= 0 {
+		tokens = append(tokens, token{term: strings.ToLower(text[start:]), start: start, end: len(text)})
+	}
+
+	return tokens
+}
+
+// withoutMetadata blanks out the metadata lines of text so they aren't searched,
+// keeping the offsets of everything else.
+func withoutMetadata(text string) string {
+	lines := strings.Split(text, "\n")
+	for i, l := range lines {
+		if storage.StripMetadata(l) == "" {
+			lines[i] = strings.Repeat(" ", len(l))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// parseQuery splits a query into phrases.
+// Words in double quotes form a single phrase, every other word is a phrase on its own.
+func parseQuery(query string) [][]string {
+	var phrases [][]string
+
+	for i, part := range strings.Split(query, "\"") {
+		words := terms(tokenize(part))
+		if len(words) == 0 {
+			continue
+		}
+		if i%2 == 1 {
+			phrases = append(phrases, words)
+			continue
+		}
+		for _, t := range words {
+			phrases = append(phrases, []string{t})
+		}
+	}
+
+	return phrases
+}
+
+func terms(tokens []token) []string {
+	terms := make([]string, len(tokens))
+	for i, t := range tokens {
+		terms[i] = t.term
+	}
+	return terms
+}
+
+// Search returns the entries that contain every word and phrase in query,
+// best matches first. Phrases are enclosed in double quotes.
+//
+// When revisions are indexed only the best matching revision of each entry is returned.
+func (idx *Index) Search(query string) []Result {
+	phrases := parseQuery(query)
+	if len(phrases) == 0 {
+		return nil
+	}
+
+	scores := make(map[int]float64)
+	matches := make(map[int][][2]int)
+
+	for i, phrase := range phrases {
+		found := idx.findPhrase(phrase)
+
+		for doc := range scores {
+			if _, ok := found[doc]; !ok {
+				delete(scores, doc)
+			}
+		}
+
+		for doc, positions := range found {
+			if _, ok := scores[doc]; !ok && i > 0 {
+				continue
+			}
+			scores[doc] += idx.score(phrase, doc, len(positions), len(found))
+			for _, pos := range positions {
+				tokens := idx.docs[doc].tokens
+				matches[doc] = append(matches[doc], [2]int{tokens[pos].start, tokens[pos+len(phrase)-1].end})
+			}
+		}
+	}
+
+	best := make(map[string]Result)
+	for doc, score := range scores {
+		entry := idx.docs[doc].entry
+		r := Result{Entry: entry, Score: score, Matches: matches[doc], doc: doc}
+		sort.Slice(r.Matches, func(i, j int) bool { return r.Matches[i][0] < r.Matches[j][0] })
+		// Prefer newer revisions when they score the same.
+		if prev, ok := best[entry.Id()]; !ok || r.Score > prev.Score || (r.Score == prev.Score && doc > prev.doc) {
+			best[entry.Id()] = r
+		}
+	}
+
+	results := make([]Result, 0, len(best))
+	for _, r := range best {
+		results = append(results, r)
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].Entry.Id() < results[j].Entry.Id()
+	})
+
+	return results
+}
+
+// findPhrase returns the token positions where phrase starts, by document.
+func (idx *Index) findPhrase(phrase []string) map[int][]int {
+	found := make(map[int][]int)
+
+	for _, p := range idx.postings[phrase[0]] {
+		tokens := idx.docs[p.doc].tokens
+		for _, pos := range p.positions {
+			if pos+len(phrase) > len(tokens) {
+				continue
+			}
+			match := true
+			for j, term := range phrase[1:] {
+				if tokens[pos+j+1].term != term {
+					match = false
+					break
+				}
+			}
+			if match {
+				found[p.doc] = append(found[p.doc], pos)
+			}
+		}
+	}
+
+	return found
+}
+
+// score is the BM25 score of phrase occurring freq times in doc.
+// docs is the number of documents the phrase occurs in.
+func (idx *Index) score(phrase []string, doc int, freq int, docs int) float64 {
+	n := float64(len(idx.docs))
+	df := float64(docs)
+	idf := math.Log(1 + (n-df+0.5)/(df+0.5))
+	length := float64(len(idx.docs[doc].tokens))
+	tf := float64(freq)
+
+	// Phrases are rarer than single words so weigh them by their length.
+	return float64(len(phrase)) * idf * tf * (k1 + 1) / (tf + k1*(1-b+b*length/max(idx.avgLength, 1)))
+}
+
+// Snippet returns about width characters of the text around the first match,
+// on a single line, with every match passed through highlight.
+func (r Result) Snippet(width int, highlight func(string) string) string {
+	text := withoutMetadata(r.Entry.Text())
+	if len(r.Matches) == 0 {
+		return strings.TrimSpace(flatten(truncate(text, width)))
+	}
+
+	first := r.Matches[0]
+	start := max(0, first[0]-width/3)
+	for start > 0 && !utf8.RuneStart(text[start]) {
+		start--
+	}
+	end := min(len(text), start+width)
+	for end < len(text) && !utf8.RuneStart(text[end]) {
+		end++
+	}
+
+	var sb strings.Builder
+	if start > 0 {
+		sb.WriteString("…")
+	}
+
+	pos := start
+	for _, m := range r.Matches {
+		if m[0] < pos || m[0] >= end {
+			continue
+		}
+		sb.WriteString(flatten(text[pos:m[0]]))
+		sb.WriteString(highlight(flatten(text[m[0]:min(m[1], end)])))
+		pos = min(m[1], end)
+	}
+	sb.WriteString(strings.TrimRightFunc(flatten(text[pos:end]), unicode.IsSpace))
+
+	if end < len(text) {
+		sb.WriteString("…")
+	}
+
+	return sb.String()
+}
+
+var whitespace = regexp.MustCompile(`\s+`)
+
+// flatten puts s on a single line.
+func flatten(s string) string {
+	return whitespace.ReplaceAllString(s, " ")
+}
+
+func truncate(s string, width int) string {
+	if len(s) <= width {
+		return s
+	}
+	end := width
+	for end > 0 && !utf8.RuneStart(s[end]) {
+		end--
+	}
+	return s[:end] + "…"
+}
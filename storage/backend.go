@@ -0,0 +1,12 @@
+package storage
+
+// Backend is where a Storage reads its entries from and appends new revisions to.
+type Backend interface {
+	// Sources returns the sources (e.g. files) entries are loaded from.
+	Sources() []string
+	// Load returns every entry in source, oldest first.
+	Load(source string) ([]Entry, error)
+	// Append writes entry as the newest revision in entry.File()
+	// and returns the entry as it was stored.
+	Append(entry Entry) (Entry, error)
+}
@@ -0,0 +1,129 @@
+package storage
+
+import (
+	"fmt"
+	"github.com/samber/lo"
+	"sort"
+	"strings"
+)
+
+// BacklinkKind is how an entry refers to another.
+type BacklinkKind int
+
+const (
+	// {$id} or [_metadata_:link]:# "$id".
+	LinkBacklink BacklinkKind = iota
+	// [_metadata_:include]:# "$id".
+	IncludeBacklink
+	// [[Title]], where Title is the title or an alias of the entry.
+	WikiLinkBacklink
+	// $id anywhere else.
+	MentionBacklink
+)
+
+func (k BacklinkKind) String() string {
+	switch k {
+	case LinkBacklink:
+		return "link"
+	case IncludeBacklink:
+		return "include"
+	case WikiLinkBacklink:
+		return "wiki link"
+	case MentionBacklink:
+		return "mention"
+	}
+	return fmt.Sprintf("BacklinkKind(%d)", int(k))
+}
+
+// Backlink is an entry that refers to another.
+type Backlink struct {
+	Entry Entry
+	Kind  BacklinkKind
+	// The line around the first reference.
+	Context string
+}
+
+// How many characters of context are kept on each side of a reference.
+const backlinkContext = 30
+
+// Backlinks returns the entries that link to, include or mention the entry with the given id, newest first.
+func (s *Storage) Backlinks(id string) []Backlink {
+	_, incoming := s.Relations(id)
+	from := lo.Uniq(lo.FilterMap(incoming, func(edge Edge, index int) (string, bool) {
+		return edge.From, edge.Reason == Mention || edge.Reason == TitleLink
+	}))
+
+	entries := lo.Filter(lo.FilterMap(from, func(id string, index int) (Entry, bool) {
+		return s.GetLatest(id)
+	}), func(e Entry, index int) bool {
+		return !e.IsDeleted()
+	})
+	sort.Sort(sort.Reverse(ByIndex(entries)))
+
+	return lo.FilterMap(entries, func(e Entry, index int) (Backlink, bool) {
+		return s.backlink(e, id)
+	})
+}
+
+// backlink finds the first reference to id in e.
+func (s *Storage) backlink(e Entry, id string) (Backlink, bool) {
+	mention := "$" + id
+	var f fence
+
+	for n, l := range splitLines(e.text) {
+		line := l.text
+		// Wiki links in code aren't links, though mentions still count.
+		fenced := f.update(line, n+1)
+
+		for _, link := range lo.Ternary(fenced, nil, WikiLinks(line)) {
+			if lo.Contains(s.ResolveWikiLink(link.Target), id) {
+				i := strings.Index(line, link.String())
+				return Backlink{Entry: e, Kind: WikiLinkBacklink, Context: context(line, i, i+len(link.String()))}, true
+			}
+		}
+
+		i := mentionIndex(line, id)
+		if i < 0 {
+			continue
+		}
+
+		if m := metadataLine.FindStringSubmatch(line); m != nil {
+			kind := MentionBacklink
+			switch m[1] {
+			case "include":
+				kind = IncludeBacklink
+			case "link":
+				kind = LinkBacklink
+			}
+			return Backlink{Entry: e, Kind: kind, Context: m[2]}, true
+		}
+
+		kind := MentionBacklink
+		if strings.Contains(line, "{"+mention+"}") {
+			kind = LinkBacklink
+		}
+		return Backlink{Entry: e, Kind: kind, Context: context(line, i, i+len(mention))}, true
+	}
+
+	return Backlink{}, false
+}
+
+// context returns line[start:end] with some of the text around it.
+func context(line string, start int, end int) string {
+	before, after := []rune(line[:start]), []rune(line[end:])
+
+	var sb strings.Builder
+	if len(before) > backlinkContext {
+		sb.WriteString("…")
+		before = before[len(before)-backlinkContext:]
+	}
+	sb.WriteString(string(before))
+	sb.WriteString(line[start:end])
+	if len(after) > backlinkContext {
+		sb.WriteString(string(after[:backlinkContext]))
+		sb.WriteString("…")
+	} else {
+		sb.WriteString(string(after))
+	}
+	return strings.TrimSpace(sb.String())
+}
@@ -0,0 +1,261 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Bump whenever cacheFile, cachedEntry or cachedGraph change.
+const cacheVersion = 2
+
+// cacheFile is the parsed contents of a single notes file.
+type cacheFile struct {
+	Version int
+	Path    string
+	Size    int64
+	ModTime time.Time
+	// sha256 of the file's contents.
+	Hash    string
+	Entries []cachedEntry
+}
+
+// cachedGraph is the relation graph of every file the backend was given.
+type cachedGraph struct {
+	Version int
+	// The sha256 of every file the graph was built from.
+	Hashes map[string]string
+	Edges  []Edge
+}
+
+type cachedEntry struct {
+	Id        string
+	Text      string
+	Start     int
+	End       int
+	StartLine int
+	EndLine   int
+	Metadata  map[string][]string
+}
+
+// DefaultCacheDir returns the directory the parsed notes files are cached in.
+func DefaultCacheDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "gotes")
+}
+
+// NewCachedFileBackend is like NewFileBackend but caches the parsed files in dir.
+//
+// A file is only parsed again when its size, modification time and contents change.
+// When a file was only appended to, only the new entries are parsed.
+// The relation graph is cached too and only rebuilt when any of the files changed.
+func NewCachedFileBackend(files []string, dir string) Backend {
+	return &fileBackend{files: files, cacheDir: dir}
+}
+
+func (b *fileBackend) cachePath(file string) string {
+	abs, err := filepath.Abs(file)
+	if err != nil {
+		abs = file
+	}
+	h := sha256.Sum256([]byte(abs))
+	return filepath.Join(b.cacheDir, hex.EncodeToString(h[:])+".gob")
+}
+
+func (b *fileBackend) readCache(file string) (cacheFile, bool) {
+	var c cacheFile
+
+	data, err := os.ReadFile(b.cachePath(file))
+	if err != nil {
+		return c, false
+	}
+
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&c); err != nil {
+		return c, false
+	}
+
+	return c, c.Version == cacheVersion
+}
+
+// writeCache stores the parsed file. Failing to write the cache isn't fatal
+// so errors are ignored.
+func (b *fileBackend) writeCache(c cacheFile) {
+	b.writeGob(b.cachePath(c.Path), c)
+}
+
+func (b *fileBackend) setHash(file string, hash string) {
+	if b.hashes == nil {
+		b.hashes = make(map[string]string)
+	}
+	b.hashes[file] = hash
+}
+
+// graphPath is where the graph of the backend's files is cached.
+func (b *fileBackend) graphPath() string {
+	abs := make([]string, 0, len(b.files))
+	for _, file := range b.files {
+		a, err := filepath.Abs(file)
+		if err != nil {
+			a = file
+		}
+		abs = append(abs, a)
+	}
+	slices.Sort(abs)
+	h := sha256.Sum256([]byte(strings.Join(abs, "\x00")))
+	return filepath.Join(b.cacheDir, "graph-"+hex.EncodeToString(h[:])+".gob")
+}
+
+// readGraph returns the cached edges if none of the files changed since they were cached.
+func (b *fileBackend) readGraph() ([]Edge, bool) {
+	if b.cacheDir == "" || len(b.hashes) != len(b.files) {
+		return nil, false
+	}
+
+	data, err := os.ReadFile(b.graphPath())
+	if err != nil {
+		return nil, false
+	}
+
+	var c cachedGraph
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&c); err != nil {
+		return nil, false
+	}
+
+	return c.Edges, c.Version == cacheVersion && maps.Equal(c.Hashes, b.hashes)
+}
+
+// writeGraph caches the edges of the graph built from the files as last loaded.
+func (b *fileBackend) writeGraph(edges []Edge) {
+	if b.cacheDir == "" || len(b.hashes) != len(b.files) {
+		return
+	}
+
+	b.writeGob(b.graphPath(), cachedGraph{
+		Version: cacheVersion,
+		Hashes:  maps.Clone(b.hashes),
+		Edges:   edges,
+	})
+}
+
+// writeGob atomically replaces path with v encoded as gob, ignoring errors.
+func (b *fileBackend) writeGob(path string, v any) {
+	if err := os.MkdirAll(b.cacheDir, 0700); err != nil {
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return
+	}
+
+	tmp, err := os.CreateTemp(b.cacheDir, ".tmp-*")
+	if err != nil {
+		return
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(buf.Bytes()); err != nil {
+		tmp.Close()
+		return
+	}
+	if err := tmp.Close(); err != nil {
+		return
+	}
+
+	os.Rename(tmp.Name(), path)
+}
+
+func hash(b []byte) string {
+	h := sha256.Sum256(b)
+	return hex.EncodeToString(h[:])
+}
+
+// loadCached loads file using the cache where possible.
+func (b *fileBackend) loadCached(file string) ([]Entry, error) {
+	info, err := os.Stat(file)
+	if err != nil {
+		return nil, err
+	}
+
+	c, ok := b.readCache(file)
+	if ok && c.Size == info.Size() && c.ModTime.Equal(info.ModTime()) {
+		b.setHash(file, c.Hash)
+		return c.entries(file), nil
+	}
+
+	text, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	fresh := cacheFile{
+		Version: cacheVersion,
+		Path:    file,
+		Size:    int64(len(text)),
+		ModTime: info.ModTime(),
+		Hash:    hash(text),
+	}
+
+	switch {
+	case ok && c.Hash == fresh.Hash:
+		// Only the modification time changed.
+		fresh.Entries = c.Entries
+	case ok && c.Size < fresh.Size && len(c.Entries) > 0 && hash(text[:c.Size]) == c.Hash:
+		// The file was appended to. Parse from the start of the last entry
+		// in case it was extended.
+		last := c.Entries[len(c.Entries)-1]
+		sections := tokenize(string(text[last.Start:]))
+		fresh.Entries = c.Entries[:len(c.Entries)-1]
+		for _, s := range sections {
+			s.start += last.Start
+			s.end += last.Start
+			s.startLine += last.StartLine - 1
+			s.endLine += last.StartLine - 1
+			fresh.Entries = append(fresh.Entries, toCachedEntry(NewEntry(file, s.text, s.start, s.end, 0), s))
+		}
+	default:
+		sections := tokenize(string(text))
+		for _, s := range sections {
+			fresh.Entries = append(fresh.Entries, toCachedEntry(NewEntry(file, s.text, s.start, s.end, 0), s))
+		}
+	}
+
+	b.writeCache(fresh)
+	b.setHash(file, fresh.Hash)
+
+	return fresh.entries(file), nil
+}
+
+func toCachedEntry(e Entry, s section) cachedEntry {
+	return cachedEntry{
+		Id:        e.id,
+		Text:      e.text,
+		Start:     s.start,
+		End:       s.end,
+		StartLine: s.startLine,
+		EndLine:   s.endLine,
+		Metadata:  e.metadata,
+	}
+}
+
+func (c cacheFile) entries(file string) []Entry {
+	entries := make([]Entry, 0, len(c.Entries))
+
+	for index, ce := range c.Entries {
+		entry := newEntry(file, ce.Text, ce.Id, ce.Metadata, ce.Start, ce.End, index)
+		entry.startLine, entry.endLine = ce.StartLine, ce.EndLine
+		entries = append(entries, entry)
+	}
+
+	return entries
+}
@@ -0,0 +1,180 @@
+package storage
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// CompactOptions select the revisions that Compact keeps.
+// A revision is kept if either option keeps it. The latest revision of every entry is always kept.
+type CompactOptions struct {
+	// Keep the latest Keep revisions of each entry. Zero keeps only the latest.
+	Keep int
+	// Keep revisions updated after Since. Revisions without an updated time are only kept by Keep.
+	Since time.Time
+	// Pruned revisions are appended to this file, if set.
+	Archive string
+	// Report what would be pruned without changing any files.
+	DryRun bool
+}
+
+// CompactReport describes what Compact pruned, or would prune in a dry run.
+type CompactReport struct {
+	File string
+	// Number of revisions kept.
+	Kept   int
+	Pruned []Entry
+	// File sizes in bytes before and after compacting.
+	Size          int64
+	CompactedSize int64
+}
+
+// Compact rewrites file without the revisions that opts don't keep.
+//
+// Only the revisions within file are considered. The file is locked while it's rewritten
+// and replaced atomically, so readers see either the old or the new contents.
+func Compact(file string, opts CompactOptions) (CompactReport, error) {
+	report := CompactReport{File: file}
+
+	f, err := openLocked(file, os.O_RDONLY)
+	if err != nil {
+		return report, err
+	}
+	defer f.Close()
+	defer unlock(f)
+
+	raw, err := io.ReadAll(f)
+	if err != nil {
+		return report, err
+	}
+	report.Size = int64(len(raw))
+
+	sections := tokenize(string(raw))
+
+	entries := make([]Entry, len(sections))
+	revisions := make(map[string]int)
+	for i, s := range sections {
+		entries[i] = NewEntry(file, s.text, s.start, s.end, i)
+		entries[i].startLine, entries[i].endLine = s.startLine, s.endLine
+		revisions[entries[i].Id()]++
+	}
+
+	// Walk backwards so that the number of newer revisions of each entry is known.
+	var kept []string
+	var pruned []string
+	newer := make(map[string]int)
+	for i := len(entries) - 1; i >= 0; i-- {
+		e := entries[i]
+		text := string(raw[e.start:e.end])
+		if opts.keeps(e, newer[e.Id()]) {
+			kept = append(kept, text)
+		} else {
+			pruned = append(pruned, text)
+			report.Pruned = append(report.Pruned, e)
+		}
+		newer[e.Id()]++
+	}
+	report.Kept = len(kept)
+	slices.Reverse(kept)
+	slices.Reverse(pruned)
+	slices.Reverse(report.Pruned)
+
+	compacted := strings.Join(kept, separator)
+	report.CompactedSize = int64(len(compacted))
+
+	if opts.DryRun || len(pruned) == 0 {
+		if len(pruned) == 0 {
+			report.CompactedSize = report.Size
+		}
+		return report, nil
+	}
+
+	// Archive first so the pruned revisions are never only in memory.
+	if opts.Archive != "" {
+		if err := appendRaw(opts.Archive, strings.Join(pruned, separator)); err != nil {
+			return report, fmt.Errorf("archiving to %s: %w", opts.Archive, err)
+		}
+	}
+
+	if err := replace(file, compacted); err != nil {
+		return report, fmt.Errorf("compacting %s: %w", file, err)
+	}
+
+	return report, nil
+}
+
+// ArchivePath returns the sidecar file that pruned revisions of file are archived in,
+// e.g. notes.archive.md for notes.md.
+func ArchivePath(file string) string {
+	ext := filepath.Ext(file)
+	return strings.TrimSuffix(file, ext) + ".archive" + ext
+}
+
+// keeps returns whether the revision e, which has newer revisions after it, is kept.
+func (opts CompactOptions) keeps(e Entry, newer int) bool {
+	if newer == 0 || newer < opts.Keep {
+		return true
+	}
+	updated, ok := e.Updated()
+	return ok && !opts.Since.IsZero() && updated.After(opts.Since)
+}
+
+// appendRaw appends already escaped entries to file.
+func appendRaw(file string, text string) error {
+	f, err := openLocked(file, os.O_APPEND|os.O_RDWR|os.O_CREATE)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	defer unlock(f)
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() > 0 {
+		text = separator + text
+	}
+
+	if _, err := f.WriteString(text); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+// replace atomically replaces the contents of file with text.
+func replace(file string, text string) error {
+	info, err := os.Stat(file)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(file), "."+filepath.Base(file)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.WriteString(text); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(info.Mode().Perm()); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), file)
+}
@@ -0,0 +1,185 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// revision returns the text of a revision of id updated on the given day of January 2024.
+func revision(id string, day int) string {
+	return fmt.Sprintf("# %s on day %d\n[_metadata_:id]:# %q\n[_metadata_:updated]:# \"2024-01-%02dT00:00:00Z\"", id, day, id, day)
+}
+
+// notes has three revisions of a and two of b, interleaved.
+var notes = []string{
+	revision("a", 1),
+	revision("b", 2),
+	revision("a", 3),
+	revision("b", 4),
+	revision("a", 5),
+}
+
+func writeNotes(t *testing.T, revisions []string) string {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), "notes.md")
+	if err := os.WriteFile(file, []byte(strings.Join(revisions, separator)+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+// readRevisions returns the text of every revision in file, without the file's final newline.
+func readRevisions(t *testing.T, file string) []string {
+	t.Helper()
+	text, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var revisions []string
+	for _, s := range tokenize(string(text)) {
+		revisions = append(revisions, strings.TrimSuffix(s.text, "\n"))
+	}
+	return revisions
+}
+
+func TestCompact(t *testing.T) {
+	tests := []struct {
+		name   string
+		opts   CompactOptions
+		kept   []string
+		pruned []string
+	}{
+		{
+			name:   "latest only",
+			opts:   CompactOptions{},
+			kept:   []string{notes[3], notes[4]},
+			pruned: []string{notes[0], notes[1], notes[2]},
+		},
+		{
+			name:   "keep",
+			opts:   CompactOptions{Keep: 2},
+			kept:   []string{notes[1], notes[2], notes[3], notes[4]},
+			pruned: []string{notes[0]},
+		},
+		{
+			name:   "since",
+			opts:   CompactOptions{Since: time.Date(2024, 1, 2, 12, 0, 0, 0, time.UTC)},
+			kept:   []string{notes[2], notes[3], notes[4]},
+			pruned: []string{notes[0], notes[1]},
+		},
+		{
+			name: "keep or since",
+			opts: CompactOptions{Keep: 2, Since: time.Date(2023, 12, 31, 0, 0, 0, 0, time.UTC)},
+			kept: notes,
+		},
+		{
+			name: "nothing to prune",
+			opts: CompactOptions{Keep: 3},
+			kept: notes,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := writeNotes(t, notes)
+
+			report, err := Compact(file, tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := readRevisions(t, file); !slices.Equal(got, tt.kept) {
+				t.Errorf("kept %q, want %q", got, tt.kept)
+			}
+			var pruned []string
+			for _, e := range report.Pruned {
+				pruned = append(pruned, e.Text())
+			}
+			if !slices.Equal(pruned, tt.pruned) {
+				t.Errorf("pruned %q, want %q", pruned, tt.pruned)
+			}
+			if report.Kept != len(tt.kept) {
+				t.Errorf("Kept = %d, want %d", report.Kept, len(tt.kept))
+			}
+
+			info, err := os.Stat(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if report.CompactedSize != info.Size() {
+				t.Errorf("CompactedSize = %d, but the file has %d bytes", report.CompactedSize, info.Size())
+			}
+		})
+	}
+}
+
+func TestCompactDryRun(t *testing.T) {
+	file := writeNotes(t, notes)
+	before, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	archive := ArchivePath(file)
+
+	report, err := Compact(file, CompactOptions{DryRun: true, Archive: archive})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	after, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(after) != string(before) {
+		t.Errorf("dry run changed the file to %q", after)
+	}
+	if _, err := os.Stat(archive); !os.IsNotExist(err) {
+		t.Errorf("dry run created the archive: %v", err)
+	}
+	if len(report.Pruned) != 3 || report.Kept != 2 {
+		t.Errorf("dry run would prune %d and keep %d, want 3 and 2", len(report.Pruned), report.Kept)
+	}
+	if report.Size != int64(len(before)) || report.CompactedSize >= report.Size {
+		t.Errorf("Size = %d, CompactedSize = %d for a %d byte file", report.Size, report.CompactedSize, len(before))
+	}
+}
+
+func TestCompactArchive(t *testing.T) {
+	file := writeNotes(t, notes)
+	archive := ArchivePath(file)
+	if archive != strings.TrimSuffix(file, ".md")+".archive.md" {
+		t.Errorf("ArchivePath(%q) = %q", file, archive)
+	}
+	// Pruned revisions are appended to an existing archive.
+	earlier := revision("c", 1)
+	if err := os.WriteFile(archive, []byte(earlier), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Compact(file, CompactOptions{Archive: archive}); err != nil {
+		t.Fatal(err)
+	}
+
+	kept := readRevisions(t, file)
+	archived := readRevisions(t, archive)
+	if want := []string{notes[3], notes[4]}; !slices.Equal(kept, want) {
+		t.Errorf("kept %q, want %q", kept, want)
+	}
+	if want := []string{earlier, notes[0], notes[1], notes[2]}; !slices.Equal(archived, want) {
+		t.Errorf("archived %q, want %q", archived, want)
+	}
+
+	// No revision was lost.
+	all := append(archived[1:], kept...)
+	slices.Sort(all)
+	want := slices.Clone(notes)
+	slices.Sort(want)
+	if !slices.Equal(all, want) {
+		t.Errorf("kept and archived revisions are %q, want %q", all, want)
+	}
+}
@@ -14,7 +14,10 @@ type Entry struct {
 	file           string
 	start          int
 	end            int
+	startLine      int
+	endLine        int
 	text           string
+	metadata       map[string][]string
 	relatedIds     []string
 	relatedRegexps []*regexp.Regexp
 	// Index of the entry within the file
@@ -23,7 +26,7 @@ type Entry struct {
 }
 
 // start(inclusive) and end(exclusive) are the
-// offsets of text within file.
+// byte offsets of text within file.
 // index is the index of the text within the file.
 // E.g. The first entry in the file has index 0, second index 2, etc.
 func NewEntry(file string, text string, start int, end int, index int) Entry {
@@ -36,10 +39,16 @@ func NewEntry(file string, text string, start int, end int, index int) Entry {
 		h.Write([]byte(text))
 		id = hex.EncodeToString(h.Sum(nil))
 		text += fmt.Sprintf("\n[_metadata_:id]:# \"%s\"", id)
+		metadata["id"] = []string{id}
 	} else {
 		id = lo.LastOrEmpty(ids)
 	}
 
+	return newEntry(file, text, id, metadata, start, end, index)
+}
+
+// newEntry creates an entry whose id and metadata have already been parsed from text.
+func newEntry(file string, text string, id string, metadata map[string][]string, start int, end int, index int) Entry {
 	isNotEmpty := func(s string, index int) bool {
 		return s != ""
 	}
@@ -52,7 +61,7 @@ func NewEntry(file string, text string, start int, end int, index int) Entry {
 	isId := hasPrefix("id=")
 	removePrefix := func(prefix string) func(string, int) string {
 		return func(identifier string, index int) string {
-			return strings.TrimLeft(identifier, prefix)
+			return strings.TrimPrefix(identifier, prefix)
 		}
 	}
 	relatedIds := lo.Map(lo.Filter(relatedIdentifiers, isId), removePrefix("id="))
@@ -61,11 +70,9 @@ func NewEntry(file string, text string, start int, end int, index int) Entry {
 		r, _ := regexp.Compile(identifier)
 		return r
 	}
-	// Auto-match when an entry has this entry's id in its body.
-	relatedRegexps := []*regexp.Regexp{createRegexp(fmt.Sprintf("\\$%s", id), 0)}
-
 	isRegexp := hasPrefix("regexp=")
-	relatedRegexps = append(relatedRegexps, lo.Map(lo.Filter(relatedIdentifiers, isRegexp), createRegexp)...)
+	relatedRegexps := lo.Map(lo.Map(lo.Filter(relatedIdentifiers, isRegexp), removePrefix("regexp=")), createRegexp)
+	relatedRegexps = lo.Compact(relatedRegexps)
 
 	return Entry{
 		id:             id,
@@ -73,6 +80,7 @@ func NewEntry(file string, text string, start int, end int, index int) Entry {
 		start:          start,
 		end:            end,
 		text:           text,
+		metadata:       metadata,
 		relatedIds:     relatedIds,
 		relatedRegexps: relatedRegexps,
 		index:          index,
@@ -99,14 +107,58 @@ func (e Entry) End() int {
 	return e.end
 }
 
+// StartLine is the line (starting at 1) the entry begins on within its file,
+// or 0 if the position is unknown.
+func (e Entry) StartLine() int {
+	return e.startLine
+}
+
+// EndLine is the last line (inclusive) of the entry within its file,
+// or 0 if the position is unknown.
+func (e Entry) EndLine() int {
+	return e.endLine
+}
+
+// LineOf returns the line within the file that the first occurrence of s
+// in the entry's text is on, falling back to StartLine.
+func (e Entry) LineOf(s string) int {
+	i := strings.Index(e.text, s)
+	if i < 0 || e.startLine == 0 {
+		return e.startLine
+	}
+	return e.startLine + strings.Count(e.text[:i], "\n")
+}
+
+// UnterminatedFence returns the line within the file of a code fence in the entry
+// that's never closed. Such a fence swallows the rest of the file.
+func (e Entry) UnterminatedFence() (int, bool) {
+	line, ok := unterminatedFence(e.text)
+	return e.startLine + line - 1, ok
+}
+
 func (e Entry) Text() string {
 	return e.text
 }
 
+// Metadata returns the entry's metadata values by key.
+func (e Entry) Metadata() map[string][]string {
+	return e.metadata
+}
+
+// Title is the first line of the entry.
+func (e Entry) Title() string {
+	return lo.FirstOrEmpty(strings.Split(e.text, "\n"))
+}
+
 func (e Entry) RelatedIds() []string {
 	return e.relatedIds
 }
 
+// regexpRelations returns the regexps from the entry's "regexp=" related metadata.
+func (e Entry) regexpRelations() []*regexp.Regexp {
+	return e.relatedRegexps
+}
+
 func (e Entry) IsRelated(e2 Entry) bool {
 	return isRelated(e, e2) || isRelated(e2, e)
 }
@@ -117,6 +169,11 @@ func isRelated(e1 Entry, e2 Entry) bool {
 		return true
 	}
 
+	// Auto-match when an entry has this entry's id in its body.
+	if e2.Id() != "" && mentionIndex(e1.Text(), e2.Id()) >= 0 {
+		return true
+	}
+
 	matches := func(r *regexp.Regexp, index int) bool {
 		return r.Match([]byte(e1.Text()))
 	}
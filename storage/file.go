@@ -0,0 +1,157 @@
+package storage
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+const separator = "\n---\n"
+
+// NewFileBackend returns a Backend that stores entries in flat
+// Markdown files, separated by "---" lines. See format.go for details.
+func NewFileBackend(files []string) Backend {
+	return &fileBackend{files: files}
+}
+
+type fileBackend struct {
+	files []string
+	// Where parsed files are cached. Caching is disabled when empty.
+	cacheDir string
+	// sha256 of the contents of each file as last loaded from the cache.
+	hashes map[string]string
+}
+
+func (b *fileBackend) Sources() []string {
+	return b.files
+}
+
+func (b *fileBackend) Load(file string) ([]Entry, error) {
+	if b.cacheDir != "" {
+		return b.loadCached(file)
+	}
+
+	text, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	sections := tokenize(string(text))
+
+	entries := []Entry{}
+
+	for index, section := range sections {
+		entry := NewEntry(file, section.text, section.start, section.end, index)
+		entry.startLine, entry.endLine = section.startLine, section.endLine
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+// Append appends entry to its file while holding an exclusive advisory lock on it,
+// so concurrent gotes processes (and scripts using flock) can't interleave writes.
+// The write is synced to disk and read back to make sure it parses as the same entry.
+func (b *fileBackend) Append(entry Entry) (Entry, error) {
+	f, err := openLocked(entry.File(), os.O_APPEND|os.O_RDWR|os.O_CREATE)
+	if err != nil {
+		return Entry{}, err
+	}
+
+	defer f.Close()
+	defer unlock(f)
+
+	existing, err := io.ReadAll(f)
+	if err != nil {
+		return Entry{}, err
+	}
+
+	escaped := escape(entry.String())
+	text := escaped
+	entry.start, entry.startLine = 0, 1
+	if len(existing) > 0 {
+		text = separator + text
+		entry.start = len(existing) + len(separator)
+		entry.startLine = strings.Count(string(existing), "\n") + 3
+	}
+	entry.end = entry.start + len(escaped)
+	entry.endLine = entry.startLine + strings.Count(escaped, "\n")
+
+	// Anything partially written is truncated away, so a failed append can't break the next load.
+	rollback := func(err error) (Entry, error) {
+		if terr := f.Truncate(int64(len(existing))); terr != nil {
+			return Entry{}, fmt.Errorf("%w (truncating %s: %w)", err, entry.File(), terr)
+		}
+		return Entry{}, err
+	}
+
+	if _, err := f.WriteString(text); err != nil {
+		return rollback(fmt.Errorf("appending to %s: %w", entry.File(), err))
+	}
+
+	if err := f.Sync(); err != nil {
+		return rollback(fmt.Errorf("syncing %s: %w", entry.File(), err))
+	}
+
+	appended, err := verifyAppended(f, entry)
+	if err != nil {
+		return rollback(fmt.Errorf("verifying entry appended to %s: %w", entry.File(), err))
+	}
+
+	return appended, nil
+}
+
+// openLocked opens file and takes an exclusive lock on it.
+// Compact replaces files, so it's opened again if it was replaced while waiting for the lock.
+func openLocked(file string, flag int) (*os.File, error) {
+	for {
+		f, err := os.OpenFile(file, flag, 0600)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := lock(f); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("locking %s: %w", file, err)
+		}
+
+		opened, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		current, err := os.Stat(file)
+		if err == nil && os.SameFile(opened, current) {
+			return f, nil
+		}
+
+		f.Close()
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+}
+
+// verifyAppended reads entry back from f and checks that it parses to an entry with the same id.
+// The entry as it was read back is returned. Its text may differ slightly from entry's,
+// e.g. if escaping closed an unterminated code fence.
+func verifyAppended(f *os.File, entry Entry) (Entry, error) {
+	written := make([]byte, entry.end-entry.start)
+	if _, err := f.ReadAt(written, int64(entry.start)); err != nil {
+		return entry, err
+	}
+
+	sections := tokenize(string(written))
+	if len(sections) != 1 {
+		return entry, fmt.Errorf("read back %d entries", len(sections))
+	}
+
+	parsed := NewEntry(entry.File(), sections[0].text, entry.start, entry.end, entry.index)
+	if parsed.Id() != entry.Id() {
+		return entry, fmt.Errorf("read back id %s, expected %s", parsed.Id(), entry.Id())
+	}
+	parsed.startLine, parsed.endLine = entry.startLine, entry.endLine
+
+	return parsed, nil
+}
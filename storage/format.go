@@ -0,0 +1,235 @@
+package storage
+
+import (
+	"regexp"
+	"strings"
+)
+
+// The notes file format is a list of entries separated by lines containing only "---".
+//
+// A "---" line isn't treated as a separator when it's:
+//
+//  1. Inside a fenced code block (``` or ~~~).
+//  2. Part of a YAML front-matter block at the very start of an entry.
+//  3. Escaped as "\---". One backslash is removed when reading and added when writing.
+//
+// Both "\n" and "\r\n" line endings are accepted. Offsets always refer to the
+// bytes in the file, so they include any "\r".
+//
+// Sections that are empty (e.g. after a doubled or trailing separator) are skipped.
+// A code fence that's never closed runs to the end of the file;
+// Entry.UnterminatedFence reports it.
+
+var (
+	separatorLine   = regexp.MustCompile(`^---$`)
+	escapedLine     = regexp.MustCompile(`^\\+---$`)
+	fenceLine       = regexp.MustCompile("^ {0,3}(`{3,}|~{3,})")
+	frontMatterLine = regexp.MustCompile(`^(\s*$|\s|#|- |[\w-]+:(\s|$))`)
+)
+
+// section is the text of a single entry and its position within a file.
+type section struct {
+	text string
+	// Byte offsets. start is inclusive, end is exclusive.
+	start int
+	end   int
+	// Line numbers, starting at 1. Both are inclusive.
+	startLine int
+	endLine   int
+}
+
+type line struct {
+	text string
+	// Byte offsets of the line within the file, excluding the line ending.
+	start int
+	end   int
+}
+
+func splitLines(text string) []line {
+	lines := []line{}
+	start := 0
+
+	for start <= len(text) {
+		end := strings.IndexByte(text[start:], '\n')
+		next := start + end + 1
+		if end < 0 {
+			end = len(text) - start
+			next = len(text) + 1
+		}
+		end += start
+
+		l := line{text: text[start:end], start: start, end: end}
+		if strings.HasSuffix(l.text, "\r") {
+			l.text = strings.TrimSuffix(l.text, "\r")
+			l.end--
+		}
+		lines = append(lines, l)
+		start = next
+	}
+
+	return lines
+}
+
+// fence tracks fenced code blocks while scanning lines.
+type fence struct {
+	marker string
+	line   int
+}
+
+// update returns whether l opened, closed or is inside a fenced code block.
+func (f *fence) update(l string, lineNumber int) bool {
+	m := fenceLine.FindStringSubmatch(l)
+
+	if f.marker == "" {
+		if m != nil {
+			f.marker, f.line = m[1], lineNumber
+			return true
+		}
+		return false
+	}
+
+	if m != nil && m[1][0] == f.marker[0] && len(m[1]) >= len(f.marker) && strings.TrimSpace(l[len(m[0]):]) == "" {
+		f.marker = ""
+	}
+
+	return true
+}
+
+// frontMatterEnd returns the index of the line closing the front matter
+// that starts at lines[i], or -1 if lines[i] doesn't start front matter.
+func frontMatterEnd(lines []line, i int) int {
+	if !separatorLine.MatchString(lines[i].text) {
+		return -1
+	}
+
+	hasKey := false
+	for j := i + 1; j < len(lines); j++ {
+		l := lines[j].text
+		if separatorLine.MatchString(l) {
+			if hasKey {
+				return j
+			}
+			return -1
+		}
+		if !frontMatterLine.MatchString(l) {
+			return -1
+		}
+		hasKey = hasKey || strings.Contains(l, ":")
+	}
+
+	return -1
+}
+
+// tokenize splits the contents of a notes file into entries.
+func tokenize(text string) []section {
+	lines := splitLines(text)
+	sections := []section{}
+
+	var (
+		current []string
+		first   = 0
+		f       fence
+	)
+
+	flush := func(last int) {
+		body := strings.Join(current, "\n")
+		if strings.TrimSpace(body) == "" {
+			return
+		}
+		sections = append(sections, section{
+			text:      body,
+			start:     lines[first].start,
+			end:       lines[last].end,
+			startLine: first + 1,
+			endLine:   last + 1,
+		})
+	}
+
+	for i := 0; i < len(lines); i++ {
+		l := lines[i].text
+
+		if f.update(l, i+1) {
+			current = append(current, l)
+			continue
+		}
+
+		if i == first {
+			if end := frontMatterEnd(lines, i); end >= 0 {
+				for ; i <= end; i++ {
+					current = append(current, lines[i].text)
+				}
+				i--
+				continue
+			}
+		}
+
+		if separatorLine.MatchString(l) {
+			flush(max(i-1, first))
+			current = nil
+			first = i + 1
+			continue
+		}
+
+		if escapedLine.MatchString(l) {
+			l = l[1:]
+		}
+
+		current = append(current, l)
+	}
+
+	flush(len(lines) - 1)
+
+	return sections
+}
+
+// unterminatedFence returns the line (starting at 1) of a code fence in text that's never closed.
+func unterminatedFence(text string) (int, bool) {
+	var f fence
+
+	for i, l := range splitLines(text) {
+		f.update(l.text, i+1)
+	}
+
+	return f.line, f.marker != ""
+}
+
+// escape prepares the text of an entry to be written to a notes file
+// so that it reads back as the same text.
+func escape(text string) string {
+	lines := splitLines(text)
+	out := make([]string, 0, len(lines))
+
+	var f fence
+
+	for i := 0; i < len(lines); i++ {
+		l := lines[i].text
+
+		if f.update(l, i+1) {
+			out = append(out, l)
+			continue
+		}
+
+		if i == 0 {
+			if end := frontMatterEnd(lines, i); end >= 0 {
+				for ; i <= end; i++ {
+					out = append(out, lines[i].text)
+				}
+				i--
+				continue
+			}
+		}
+
+		if separatorLine.MatchString(l) || escapedLine.MatchString(l) {
+			l = "\\" + l
+		}
+
+		out = append(out, l)
+	}
+
+	if f.marker != "" {
+		// Close the fence so it doesn't swallow the entries after this one.
+		out = append(out, f.marker)
+	}
+
+	return strings.Join(out, "\n")
+}
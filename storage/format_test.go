@@ -0,0 +1,107 @@
+package storage
+
+import (
+	"github.com/samber/lo"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestTokenize(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want []string
+	}{
+		{"single", "# A\none", []string{"# A\none"}},
+		{"separated", "# A\n---\n# B", []string{"# A", "# B"}},
+		{"empty sections", "---\n# A\n---\n---\n# B\n---\n", []string{"# A", "# B"}},
+		{"separator with spaces", "# A\n--- \n# B", []string{"# A\n--- \n# B"}},
+		{"fenced", "# A\n```\n---\n```\n---\n# B", []string{"# A\n```\n---\n```", "# B"}},
+		{"tilde fence", "# A\n~~~\n---\n~~~\n---\n# B", []string{"# A\n~~~\n---\n~~~", "# B"}},
+		{"longer closing fence", "````\n```\n---\n````\n---\n# B", []string{"````\n```\n---\n````", "# B"}},
+		{"front matter", "---\ntitle: A\ntags: [x]\n---\n# A\n---\n# B", []string{"---\ntitle: A\ntags: [x]\n---\n# A", "# B"}},
+		{"front matter after separator", "# A\n---\n---\ntitle: B\n---\n# B", []string{"# A", "---\ntitle: B\n---\n# B"}},
+		{"not front matter", "# A\n---\nnot a key\n---\n# B", []string{"# A", "not a key", "# B"}},
+		{"escaped", "# A\n\\---\n# still A", []string{"# A\n---\n# still A"}},
+		{"escaped twice", "# A\n\\\\---", []string{"# A\n\\---"}},
+		{"crlf", "# A\r\none\r\n---\r\n# B\r\n", []string{"# A\none", "# B\n"}},
+		{"unterminated fence", "# A\n```\n---\n# B", []string{"# A\n```\n---\n# B"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := lo.Map(tokenize(tt.text), func(s section, index int) string { return s.text })
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("tokenize(%q) = %q, want %q", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTokenizeOffsets(t *testing.T) {
+	text := "# A\r\none\r\n---\r\n\r\n# B\r\n```\r\n---\r\n```"
+
+	sections := tokenize(text)
+	if len(sections) != 2 {
+		t.Fatalf("got %d sections, want 2", len(sections))
+	}
+
+	want := []struct {
+		raw                string
+		startLine, endLine int
+	}{
+		{"# A\r\none", 1, 2},
+		{"\r\n# B\r\n```\r\n---\r\n```", 4, 8},
+	}
+	for i, s := range sections {
+		if raw := text[s.start:s.end]; raw != want[i].raw {
+			t.Errorf("section %d is %q in the file, want %q", i, raw, want[i].raw)
+		}
+		if s.startLine != want[i].startLine || s.endLine != want[i].endLine {
+			t.Errorf("section %d is on lines %d-%d, want %d-%d", i, s.startLine, s.endLine, want[i].startLine, want[i].endLine)
+		}
+	}
+}
+
+func TestEscapeRoundTrip(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []string
+		// The entries as read back, if they differ.
+		want []string
+	}{
+		{"plain", []string{"# A\none", "# B\ntwo"}, nil},
+		{"separator", []string{"# A\n---\nstill A", "# B"}, nil},
+		{"escaped separator", []string{"# A\n\\---\n\\\\---", "# B"}, nil},
+		{"fenced separator", []string{"# A\n```\n---\n\\---\n```", "# B"}, nil},
+		{"front matter", []string{"---\ntitle: A\n---\n# A\n---", "# B"}, nil},
+		{"separator that isn't front matter", []string{"---\n# A\n---", "# B"}, nil},
+		{
+			name:    "unterminated fence",
+			entries: []string{"# A\n```\n---", "# B"},
+			want:    []string{"# A\n```\n---\n```", "# B"},
+		},
+		{
+			name:    "crlf",
+			entries: []string{"# A\r\n---\r\none", "# B"},
+			want:    []string{"# A\n---\none", "# B"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			escaped := lo.Map(tt.entries, func(e string, index int) string { return escape(e) })
+			file := strings.Join(escaped, separator) + "\n"
+
+			got := lo.Map(tokenize(file), func(s section, index int) string { return strings.TrimSuffix(s.text, "\n") })
+			want := tt.want
+			if want == nil {
+				want = tt.entries
+			}
+			if !slices.Equal(got, want) {
+				t.Errorf("read back %q, want %q\nfile: %q", got, want, file)
+			}
+		})
+	}
+}
@@ -0,0 +1,300 @@
+package storage
+
+import (
+	"fmt"
+	"github.com/samber/lo"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Reason is why one entry is related to another.
+type Reason int
+
+const (
+	// The related metadata of From contains "id=To".
+	RelatedId Reason = iota
+	// The text of From contains $To.
+	Mention
+	// A "regexp=" related metadata of From matches the text of To.
+	RelatedRegexp
+	// The text of From contains [[Title]], where Title is the title or an alias of To.
+	TitleLink
+)
+
+func (r Reason) String() string {
+	switch r {
+	case RelatedId:
+		return "related id"
+	case Mention:
+		return "mention"
+	case RelatedRegexp:
+		return "related regexp"
+	case TitleLink:
+		return "wiki link"
+	}
+	return fmt.Sprintf("Reason(%d)", int(r))
+}
+
+// Edge relates the latest revisions of two entries.
+type Edge struct {
+	From   string
+	To     string
+	Reason Reason
+	// The related id or regexp, the mention or the wiki link that created the edge.
+	Detail string
+}
+
+func (e Edge) String() string {
+	return fmt.Sprintf("%s -> %s (%s %s)", e.From, e.To, e.Reason, e.Detail)
+}
+
+// graph holds the relations between the latest revisions of every entry.
+type graph struct {
+	out map[string][]Edge
+	in  map[string][]Edge
+	// Latest revisions by id.
+	entries map[string]Entry
+	// Entries with "regexp=" relations.
+	regexps map[string][]*regexp.Regexp
+	// Number of ids of each length. Used to find mentions without a regexp per id.
+	idLengths map[int]int
+	// Ids of the entries by their normalized titles and aliases.
+	names map[string][]string
+}
+
+func newGraph(entries []Entry) *graph {
+	g := &graph{
+		out:       make(map[string][]Edge),
+		in:        make(map[string][]Edge),
+		entries:   make(map[string]Entry),
+		regexps:   make(map[string][]*regexp.Regexp),
+		idLengths: make(map[int]int),
+	}
+
+	for _, e := range entries {
+		g.addNode(e)
+	}
+	g.indexNames()
+
+	for _, e := range entries {
+		g.addOutgoing(e)
+	}
+
+	return g
+}
+
+// graphCache is implemented by backends that can cache the graph of their sources.
+type graphCache interface {
+	// readGraph returns the cached edges if the sources didn't change since they were cached.
+	readGraph() ([]Edge, bool)
+	writeGraph(edges []Edge)
+}
+
+// loadGraph builds the graph of entries, using the backend's cached edges when they're fresh.
+func loadGraph(backend Backend, entries []Entry) *graph {
+	c, ok := backend.(graphCache)
+	if !ok {
+		return newGraph(entries)
+	}
+
+	if edges, ok := c.readGraph(); ok {
+		return newGraphWithEdges(entries, edges)
+	}
+
+	g := newGraph(entries)
+	c.writeGraph(g.edges())
+	return g
+}
+
+// newGraphWithEdges is like newGraph but uses edges instead of finding them in the entries.
+func newGraphWithEdges(entries []Entry, edges []Edge) *graph {
+	g := &graph{
+		out:       make(map[string][]Edge),
+		in:        make(map[string][]Edge),
+		entries:   make(map[string]Entry),
+		regexps:   make(map[string][]*regexp.Regexp),
+		idLengths: make(map[int]int),
+	}
+
+	for _, e := range entries {
+		g.addNode(e)
+	}
+	g.indexNames()
+
+	for _, edge := range edges {
+		g.out[edge.From] = append(g.out[edge.From], edge)
+		g.in[edge.To] = append(g.in[edge.To], edge)
+	}
+
+	return g
+}
+
+// edges returns every edge in the graph.
+func (g *graph) edges() []Edge {
+	var edges []Edge
+	for _, out := range g.out {
+		edges = append(edges, out...)
+	}
+	return edges
+}
+
+func (g *graph) addNode(e Entry) {
+	if _, ok := g.entries[e.id]; !ok {
+		g.idLengths[len(e.id)]++
+	}
+	g.entries[e.id] = e
+
+	if r := e.regexpRelations(); len(r) > 0 {
+		g.regexps[e.id] = r
+	} else {
+		delete(g.regexps, e.id)
+	}
+}
+
+func (g *graph) addEdge(edge Edge) {
+	if slices.Contains(g.out[edge.From], edge) {
+		return
+	}
+	g.out[edge.From] = append(g.out[edge.From], edge)
+	g.in[edge.To] = append(g.in[edge.To], edge)
+}
+
+func (g *graph) removeEdges(keep func(Edge) bool) {
+	for id, edges := range g.out {
+		g.out[id] = lo.Filter(edges, func(e Edge, index int) bool { return keep(e) })
+	}
+	for id, edges := range g.in {
+		g.in[id] = lo.Filter(edges, func(e Edge, index int) bool { return keep(e) })
+	}
+}
+
+// addOutgoing adds the edges from e to every other entry.
+func (g *graph) addOutgoing(e Entry) {
+	for _, id := range e.relatedIds {
+		g.addEdge(Edge{From: e.id, To: id, Reason: RelatedId, Detail: "id=" + id})
+	}
+
+	for _, id := range g.mentions(e.text) {
+		if id != e.id {
+			g.addEdge(Edge{From: e.id, To: id, Reason: Mention, Detail: "$" + id})
+		}
+	}
+
+	for _, r := range g.regexps[e.id] {
+		for id, other := range g.entries {
+			if id != e.id && r.MatchString(other.text) {
+				g.addEdge(Edge{From: e.id, To: id, Reason: RelatedRegexp, Detail: "regexp=" + r.String()})
+			}
+		}
+	}
+
+	g.addWikiLinks(e)
+}
+
+// addWikiLinks adds the edges for the wiki links in e that aren't ambiguous.
+func (g *graph) addWikiLinks(e Entry) {
+	for _, link := range WikiLinks(e.text) {
+		ids := g.names[normalizeName(link.Target)]
+		if len(ids) == 1 && ids[0] != e.id {
+			g.addEdge(Edge{From: e.id, To: ids[0], Reason: TitleLink, Detail: link.String()})
+		}
+	}
+}
+
+// indexNames indexes the titles and aliases of every entry.
+func (g *graph) indexNames() {
+	g.names = make(map[string][]string)
+	for id, e := range g.entries {
+		for _, name := range e.names() {
+			g.names[name] = append(g.names[name], id)
+		}
+	}
+}
+
+// mentions returns the ids of the entries mentioned as $id in text.
+func (g *graph) mentions(text string) []string {
+	var ids []string
+
+	for i := strings.IndexByte(text, '$'); i >= 0; {
+		rest := text[i+1:]
+		for length := range g.idLengths {
+			if length <= len(rest) && (length == len(rest) || !isIdByte(rest[length])) {
+				if _, ok := g.entries[rest[:length]]; ok {
+					ids = append(ids, rest[:length])
+				}
+			}
+		}
+
+		next := strings.IndexByte(rest, '$')
+		if next < 0 {
+			break
+		}
+		i += next + 1
+	}
+
+	return lo.Uniq(ids)
+}
+
+// mentionIndex returns the index of the first mention of id as $id in text, or -1.
+// The start of a longer id, like $abcd for abc, isn't a mention.
+func mentionIndex(text string, id string) int {
+	mention := "$" + id
+	for offset := 0; ; {
+		i := strings.Index(text[offset:], mention)
+		if i < 0 {
+			return -1
+		}
+		end := offset + i + len(mention)
+		if end == len(text) || !isIdByte(text[end]) {
+			return offset + i
+		}
+		offset += i + 1
+	}
+}
+
+// isIdByte returns whether c can be part of an id.
+func isIdByte(c byte) bool {
+	return c == '-' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+// update replaces the latest revision of e.Id() with e.
+func (g *graph) update(e Entry) {
+	old, existed := g.entries[e.id]
+	g.addNode(e)
+
+	// Drop everything that depends on the old text of e.
+	g.removeEdges(func(edge Edge) bool {
+		return edge.From != e.id && !(edge.To == e.id && edge.Reason == RelatedRegexp)
+	})
+
+	g.addOutgoing(e)
+
+	for id, regexps := range g.regexps {
+		for _, r := range regexps {
+			if id != e.id && r.MatchString(e.text) {
+				g.addEdge(Edge{From: id, To: e.id, Reason: RelatedRegexp, Detail: "regexp=" + r.String()})
+			}
+		}
+	}
+
+	if !existed || !slices.Equal(old.names(), e.names()) {
+		// Wiki links of other entries may resolve differently now.
+		g.indexNames()
+		g.removeEdges(func(edge Edge) bool {
+			return edge.Reason != TitleLink
+		})
+		for _, other := range g.entries {
+			g.addWikiLinks(other)
+		}
+	}
+
+	if !existed {
+		// Entries may have mentioned e before it existed.
+		for id, other := range g.entries {
+			if id != e.id && mentionIndex(other.text, e.id) >= 0 {
+				g.addEdge(Edge{From: id, To: e.id, Reason: Mention, Detail: "$" + e.id})
+			}
+		}
+	}
+}
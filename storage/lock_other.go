@@ -0,0 +1,18 @@
+//go:build !unix
+
+package storage
+
+import (
+	"os"
+)
+
+// Advisory locking isn't supported on this platform.
+// Appends still use O_APPEND so each write lands at the end of the file.
+
+func lock(f *os.File) error {
+	return nil
+}
+
+func unlock(f *os.File) error {
+	return nil
+}
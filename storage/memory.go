@@ -0,0 +1,42 @@
+package storage
+
+import (
+	"slices"
+)
+
+// NewMemoryBackend returns a Backend that keeps entries in memory.
+// Nothing is persisted; it's mainly useful for tests and tooling.
+func NewMemoryBackend(entries ...Entry) Backend {
+	b := &memoryBackend{entries: make(map[string][]Entry)}
+
+	for _, entry := range entries {
+		b.Append(entry)
+	}
+
+	return b
+}
+
+type memoryBackend struct {
+	sources []string
+	entries map[string][]Entry
+}
+
+func (b *memoryBackend) Sources() []string {
+	return b.sources
+}
+
+func (b *memoryBackend) Load(source string) ([]Entry, error) {
+	return slices.Clone(b.entries[source]), nil
+}
+
+func (b *memoryBackend) Append(entry Entry) (Entry, error) {
+	source := entry.File()
+	if !slices.Contains(b.sources, source) {
+		b.sources = append(b.sources, source)
+	}
+
+	entry.index = len(b.entries[source])
+	b.entries[source] = append(b.entries[source], entry)
+
+	return entry, nil
+}
@@ -0,0 +1,124 @@
+package storage
+
+import (
+	"fmt"
+	"github.com/samber/lo"
+	"slices"
+	"strings"
+	"time"
+)
+
+// TimeLayout is how created and updated metadata are formatted.
+const TimeLayout = time.RFC3339
+
+// SetMetadata sets key to value in text. The first existing line for key is replaced
+// and any others are removed. If there are none, the line is appended.
+func SetMetadata(text string, key string, value string) string {
+	line := fmt.Sprintf("[_metadata_:%s]:# \"%s\"", key, value)
+	lines := strings.Split(text, "\n")
+	replaced := false
+
+	lines = lo.FilterMap(lines, func(l string, index int) (string, bool) {
+		if !isMetadata(l) || metadataLine.FindStringSubmatch(l)[1] != key {
+			return l, true
+		}
+		if replaced {
+			return "", false
+		}
+		replaced = true
+		return line, true
+	})
+
+	if !replaced {
+		lines = append(lines, line)
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// AddMetadata appends a line setting key to value to text, unless text already has it.
+func AddMetadata(text string, key string, value string) string {
+	if slices.Contains(GetMetadata(text)[key], value) {
+		return text
+	}
+	return text + fmt.Sprintf("\n[_metadata_:%s]:# \"%s\"", key, value)
+}
+
+// RemoveMetadataValue removes the lines setting key to value from text.
+func RemoveMetadataValue(text string, key string, value string) string {
+	lines := lo.Filter(strings.Split(text, "\n"), func(l string, index int) bool {
+		if !isMetadata(l) {
+			return true
+		}
+		match := metadataLine.FindStringSubmatch(l)
+		return match[1] != key || match[2] != value
+	})
+	return strings.Join(lines, "\n")
+}
+
+// StripMetadata removes every metadata line from text.
+func StripMetadata(text string) string {
+	lines := lo.Filter(strings.Split(text, "\n"), func(l string, index int) bool {
+		return !isMetadata(l)
+	})
+	return strings.Join(lines, "\n")
+}
+
+// Stamp prepares text to be stored as a new revision by setting the updated time and author.
+// The created time is added by Storage.Write to the first revision of an entry.
+func Stamp(text string, author string, now time.Time) string {
+	text = SetMetadata(text, "updated", now.Format(TimeLayout))
+	if author != "" {
+		text = SetMetadata(text, "author", author)
+	}
+
+	return text
+}
+
+// NewRevision creates a new revision of an entry in file, stamped by author at now.
+// Both the TUI and the CLI create revisions with it so they get the same metadata.
+func NewRevision(file string, text string, author string, now time.Time) Entry {
+	return NewEntry(file, Stamp(text, author, now), 0, 0, 0)
+}
+
+// stampCreated sets the created time of the first revision of an entry to when it was updated.
+// Entries written before revisions were stamped never get one, since it isn't known.
+func stampCreated(entry Entry) Entry {
+	updated := lo.LastOrEmpty(entry.metadata["updated"])
+	if updated == "" || len(entry.metadata["created"]) > 0 {
+		return entry
+	}
+
+	stamped := NewEntry(entry.file, SetMetadata(entry.text, "created", updated), entry.start, entry.end, entry.index)
+	stamped.startLine, stamped.endLine = entry.startLine, entry.endLine
+	return stamped
+}
+
+func (e Entry) timeMetadata(key string) (time.Time, bool) {
+	value := lo.LastOrEmpty(e.metadata[key])
+	if value == "" {
+		return time.Time{}, false
+	}
+
+	t, err := time.Parse(TimeLayout, value)
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	return t, true
+}
+
+// Created is when the first revision of the entry was made, if known.
+func (e Entry) Created() (time.Time, bool) {
+	return e.timeMetadata("created")
+}
+
+// Updated is when this revision was made, if known.
+func (e Entry) Updated() (time.Time, bool) {
+	return e.timeMetadata("updated")
+}
+
+// Author is who made this revision, if known.
+func (e Entry) Author() string {
+	return lo.LastOrEmpty(e.metadata["author"])
+}
@@ -0,0 +1,86 @@
+package storage
+
+import (
+	"github.com/samber/lo"
+	"strings"
+	"time"
+)
+
+// The status metadata of an entry's latest revision hides it.
+// Archived entries keep their text, deleted ones are tombstones.
+const (
+	StatusArchived = "archived"
+	StatusDeleted  = "deleted"
+)
+
+func (e Entry) Status() string {
+	return lo.LastOrEmpty(e.metadata["status"])
+}
+
+func (e Entry) IsArchived() bool {
+	return e.Status() == StatusArchived
+}
+
+func (e Entry) IsDeleted() bool {
+	return e.Status() == StatusDeleted
+}
+
+// IsHidden returns whether the entry is archived or deleted.
+func (e Entry) IsHidden() bool {
+	return e.IsArchived() || e.IsDeleted()
+}
+
+// Archive returns text with its status set to archived.
+func Archive(text string) string {
+	return SetMetadata(text, "status", StatusArchived)
+}
+
+// Unarchive returns text without a status.
+func Unarchive(text string) string {
+	lines := lo.Filter(strings.Split(text, "\n"), func(l string, index int) bool {
+		return !isMetadata(l) || metadataLine.FindStringSubmatch(l)[1] != "status"
+	})
+	return strings.Join(lines, "\n")
+}
+
+// Tombstone returns the text of a revision that deletes the entry with the given text.
+// Only the id and creation time are kept. Earlier revisions can still be restored.
+func Tombstone(text string) string {
+	lines := lo.Filter(strings.Split(text, "\n"), func(l string, index int) bool {
+		if !isMetadata(l) {
+			return false
+		}
+		key := metadataLine.FindStringSubmatch(l)[1]
+		return key == "id" || key == "created"
+	})
+	return SetMetadata(strings.Join(lines, "\n"), "status", StatusDeleted)
+}
+
+// ArchiveRevision returns a new revision of entry that archives it.
+func ArchiveRevision(entry Entry, author string, now time.Time) Entry {
+	return NewRevision(entry.File(), Archive(entry.Text()), author, now)
+}
+
+// UnarchiveRevision returns a new revision of an archived entry that shows it again.
+func UnarchiveRevision(entry Entry, author string, now time.Time) Entry {
+	return NewRevision(entry.File(), Unarchive(entry.Text()), author, now)
+}
+
+// DeleteRevision returns a tombstone revision of entry.
+func DeleteRevision(entry Entry, author string, now time.Time) Entry {
+	return NewRevision(entry.File(), Tombstone(entry.Text()), author, now)
+}
+
+// Title returns the title to show for entry. Tombstones only hold metadata, so they're
+// shown with the title of the last revision that wasn't deleted, or their id.
+func (s *Storage) Title(entry Entry) string {
+	if !entry.IsDeleted() {
+		return entry.Title()
+	}
+
+	revisions, _ := s.Get(entry.Id())
+	before, _, _ := lo.FindLastIndexOf(revisions, func(e Entry) bool {
+		return !e.IsDeleted()
+	})
+	return lo.CoalesceOrEmpty(before.Title(), entry.Id())
+}
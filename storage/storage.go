@@ -1,40 +1,28 @@
 package storage
 
 import (
-	"github.com/charmbracelet/log"
+	"cmp"
 	"github.com/samber/lo"
-	"os"
 	"regexp"
+	"slices"
 	"sort"
+	"strconv"
 	"strings"
 )
 
 type Storage struct {
-	sourceFiles []string
-	storage     *map[string][]Entry
+	backend Backend
+	storage *map[string][]Entry
+	// Number of entries in each source, used to index new revisions.
+	counts map[string]int
+	// Relations between the latest entries. Built once everything is loaded.
+	graph *graph
 }
 
-func readFile(file string) string {
-	b, err := os.ReadFile(file)
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	return string(b)
-}
-
-func loadEntries(file string) []Entry {
-	entries := []Entry{}
-
-	notes := splitEntries(readFile(file))
-
-	for index, text := range notes {
-		entry := NewEntry(file, text, 0, 0, index)
-		entries = append(entries, entry)
-	}
-
-	return entries
-}
+var (
+	metadataLine  = regexp.MustCompile("^\\[_metadata_:*(\\w+)\\]:# \"(.*)\"$")
+	metadataCheck = regexp.MustCompile("^\\[_metadata_:\\w+\\]:# \".*\"$")
+)
 
 func GetMetadata(text string) map[string][]string {
 	lines := strings.Split(text, "\n")
@@ -46,9 +34,8 @@ func GetMetadata(text string) map[string][]string {
 	o := make(map[string][]string)
 
 	for _, ml := range metaLines {
-		r, _ := regexp.Compile("^\\[_metadata_:*(\\w+)\\]:# \"(.*)\"$")
-		key := r.FindStringSubmatch(ml)[1]
-		value := r.FindStringSubmatch(ml)[2]
+		match := metadataLine.FindStringSubmatch(ml)
+		key, value := match[1], match[2]
 		o[key] = append(o[key], value)
 	}
 
@@ -56,39 +43,112 @@ func GetMetadata(text string) map[string][]string {
 }
 
 func isMetadata(text string) bool {
-	r, _ := regexp.Compile("^\\[_metadata_:\\w+\\]:# \".*\"$")
-
-	return r.MatchString(text)
+	return metadataCheck.MatchString(text)
 }
 
-func splitEntries(text string) []string {
-	return strings.Split(text, "\n---\n")
-}
-
-func (s *Storage) loadFromFiles() {
-	for _, file := range s.sourceFiles {
-		entries := loadEntries(file)
+func (s *Storage) load() error {
+	for _, source := range s.backend.Sources() {
+		entries, err := s.backend.Load(source)
+		if err != nil {
+			return err
+		}
 
 		for _, n := range entries {
 			s.AddEntry(n)
 		}
 	}
+
+	return nil
+}
+
+// Reload replaces every entry from source with what's currently in it.
+func (s *Storage) Reload(source string) error {
+	entries, err := s.backend.Load(source)
+	if err != nil {
+		return err
+	}
+
+	sources := s.backend.Sources()
+	for id, revisions := range *s.storage {
+		revisions = lo.Filter(revisions, func(e Entry, index int) bool {
+			return e.File() != source
+		})
+		if len(revisions) == 0 {
+			delete(*s.storage, id)
+		} else {
+			(*s.storage)[id] = revisions
+		}
+	}
+
+	s.counts[source] = 0
+	for _, e := range entries {
+		id := e.Id()
+		(*s.storage)[id] = append((*s.storage)[id], e)
+		s.counts[source] = max(s.counts[source], e.index+1)
+	}
+
+	// Keep revisions in the same order as when they're first loaded.
+	for _, e := range entries {
+		slices.SortStableFunc((*s.storage)[e.Id()], func(a Entry, b Entry) int {
+			if c := cmp.Compare(slices.Index(sources, a.File()), slices.Index(sources, b.File())); c != 0 {
+				return c
+			}
+			return cmp.Compare(a.index, b.index)
+		})
+	}
+
+	s.graph = loadGraph(s.backend, s.GetAllLatestEntries())
+
+	return nil
 }
 
+// AddEntry adds entry to the in-memory store without persisting it.
+// Use Write to persist new revisions.
 func (s *Storage) AddEntry(entry Entry) {
 	id := entry.Id()
 	list, _ := (*s.storage)[id]
 	(*s.storage)[id] = append(list, entry)
+	s.counts[entry.File()] = max(s.counts[entry.File()], entry.index+1)
+	if s.graph != nil {
+		s.graph.update(entry)
+	}
 }
 
-func New(sourceFiles []string) *Storage {
+// Write appends entry to its source through the backend and
+// adds the stored revision to the store.
+func (s *Storage) Write(entry Entry) (Entry, error) {
+	entry.index = s.counts[entry.File()]
+	if _, ok := s.Get(entry.Id()); !ok {
+		entry = stampCreated(entry)
+	}
+
+	stored, err := s.backend.Append(entry)
+	if err != nil {
+		return Entry{}, err
+	}
+
+	s.AddEntry(stored)
+
+	return stored, nil
+}
+
+// Sources returns the sources the backend loads entries from.
+func (s *Storage) Sources() []string {
+	return s.backend.Sources()
+}
+
+func New(backend Backend) (*Storage, error) {
 	var s = make(map[string][]Entry)
 	store := &Storage{
-		sourceFiles: sourceFiles,
-		storage:     &s,
+		backend: backend,
+		storage: &s,
+		counts:  make(map[string]int),
 	}
-	store.loadFromFiles()
-	return store
+	if err := store.load(); err != nil {
+		return nil, err
+	}
+	store.graph = loadGraph(store.backend, store.GetAllLatestEntries())
+	return store, nil
 }
 
 func (s *Storage) Get(id string) ([]Entry, bool) {
@@ -106,13 +166,50 @@ func (s *Storage) GetLatest(id string) (Entry, bool) {
 	return lo.Last(entries)
 }
 
+// GetRevision returns a single revision of the entry with the given id.
+//
+// rev is either a revision number, where 1 is the oldest revision,
+// or relative to the latest revision in the form HEAD~N.
+func (s *Storage) GetRevision(id string, rev string) (Entry, bool) {
+	entries, ok := s.Get(id)
+	if !ok {
+		return Entry{}, false
+	}
+
+	var i int
+	var err error
+	if n, found := strings.CutPrefix(rev, "HEAD~"); found {
+		i, err = strconv.Atoi(n)
+		i = len(entries) - 1 - i
+	} else if rev == "HEAD" {
+		i = len(entries) - 1
+	} else {
+		i, err = strconv.Atoi(rev)
+		i--
+	}
+
+	if err != nil || i < 0 || i >= len(entries) {
+		return Entry{}, false
+	}
+
+	return entries[i], true
+}
+
 type ByIndex []Entry
 
 func (i ByIndex) Len() int           { return len(i) }
 func (a ByIndex) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
 func (a ByIndex) Less(i, j int) bool { return a[i].index < a[j].index }
 
+// GetLatestEntries returns the latest revision of every entry that isn't archived or deleted, newest first.
 func (s *Storage) GetLatestEntries() []Entry {
+	return lo.Filter(s.GetAllLatestEntries(), func(e Entry, index int) bool {
+		return !e.IsHidden()
+	})
+}
+
+// GetAllLatestEntries is like GetLatestEntries but includes archived and deleted entries.
+func (s *Storage) GetAllLatestEntries() []Entry {
 	entries := lo.Map(lo.Values(*s.storage), func(es []Entry, index int) Entry {
 		return lo.LastOrEmpty(es)
 	})
@@ -123,15 +220,45 @@ func (s *Storage) GetLatestEntries() []Entry {
 }
 
 func (s *Storage) FindEntriesRelatedTo(e Entry) []Entry {
-	return lo.Filter(s.GetLatestEntries(), func(e2 Entry, index int) bool {
-		return e.IsRelated(e2)
+	if latest, ok := s.GetLatest(e.Id()); !ok || latest.text != e.text {
+		// Older revisions aren't in the graph.
+		return lo.Filter(s.GetLatestEntries(), func(e2 Entry, index int) bool {
+			return e.IsRelated(e2)
+		})
+	}
+
+	outgoing, incoming := s.Relations(e.Id())
+	ids := lo.Uniq(append(
+		lo.Map(outgoing, func(edge Edge, index int) string { return edge.To }),
+		lo.Map(incoming, func(edge Edge, index int) string { return edge.From })...,
+	))
+
+	entries := lo.FilterMap(ids, func(id string, index int) (Entry, bool) {
+		return s.GetLatest(id)
 	})
+	sort.Sort(sort.Reverse(ByIndex(entries)))
+
+	return entries
 }
 
 func (s *Storage) GetRelatedTo(entry Entry) []Entry {
 	entries := s.FindEntriesRelatedTo(entry)
 
 	return lo.Filter(entries, func(e Entry, index int) bool {
-		return e.Id() != entry.Id()
+		return e.Id() != entry.Id() && !e.IsDeleted()
 	})
 }
+
+// Relations returns the relations from and to the latest revision of the entry with the given id.
+func (s *Storage) Relations(id string) (outgoing []Edge, incoming []Edge) {
+	return s.graph.out[id], s.graph.in[id]
+}
+
+// Why returns the relations between the entries with ids a and b, in either direction.
+func (s *Storage) Why(a string, b string) []Edge {
+	outgoing, incoming := s.Relations(a)
+	return append(
+		lo.Filter(outgoing, func(e Edge, index int) bool { return e.To == b }),
+		lo.Filter(incoming, func(e Edge, index int) bool { return e.From == b })...,
+	)
+}
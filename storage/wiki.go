@@ -0,0 +1,129 @@
+package storage
+
+import (
+	"github.com/samber/lo"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// [[Title]] or [[Title|label]].
+var wikiLinkPattern = regexp.MustCompile(`\[\[([^\[\]|\n]+)(?:\|([^\[\]\n]*))?\]\]`)
+
+// WikiLink links to the entry with a title or alias, like [[Title]] or [[Title|label]].
+type WikiLink struct {
+	// The title or alias that is linked to.
+	Target string
+	Label  string
+}
+
+func (l WikiLink) String() string {
+	if l.Label == "" {
+		return "[[" + l.Target + "]]"
+	}
+	return "[[" + l.Target + "|" + l.Label + "]]"
+}
+
+// ParseWikiLink parses s if it's a whole wiki link.
+func ParseWikiLink(s string) (WikiLink, bool) {
+	m := wikiLinkPattern.FindStringSubmatch(s)
+	if m == nil || m[0] != s {
+		return WikiLink{}, false
+	}
+	return WikiLink{Target: strings.TrimSpace(m[1]), Label: strings.TrimSpace(m[2])}, true
+}
+
+// WikiLinks returns the wiki links in text. Those within code aren't links,
+// the same as when the text is rendered.
+func WikiLinks(text string) []WikiLink {
+	return lo.Map(wikiLinkPattern.FindAllString(withoutCode(text), -1), func(s string, index int) WikiLink {
+		l, _ := ParseWikiLink(s)
+		return l
+	})
+}
+
+// withoutCode removes fenced code blocks and code spans from text.
+func withoutCode(text string) string {
+	var (
+		f   fence
+		out []string
+	)
+
+	for i, l := range splitLines(text) {
+		if f.update(l.text, i+1) {
+			continue
+		}
+		out = append(out, withoutCodeSpans(l.text))
+	}
+
+	return strings.Join(out, "\n")
+}
+
+// withoutCodeSpans removes code spans from a line. A span is closed by
+// a run of the same number of backticks as the one that opened it.
+func withoutCodeSpans(line string) string {
+	var sb strings.Builder
+
+	for {
+		start := strings.IndexByte(line, '`')
+		if start < 0 {
+			break
+		}
+		n := len(line[start:]) - len(strings.TrimLeft(line[start:], "`"))
+		end := closingBackticks(line[start+n:], n)
+		if end < 0 {
+			// Not a code span, so the backticks are just text.
+			sb.WriteString(line[:start+n])
+			line = line[start+n:]
+			continue
+		}
+		sb.WriteString(line[:start])
+		line = line[start+n+end+n:]
+	}
+	sb.WriteString(line)
+
+	return sb.String()
+}
+
+// closingBackticks returns the index of the first run of exactly n backticks in s, or -1.
+func closingBackticks(s string, n int) int {
+	for i := 0; i < len(s); {
+		if s[i] != '`' {
+			i++
+			continue
+		}
+		run := len(s[i:]) - len(strings.TrimLeft(s[i:], "`"))
+		if run == n {
+			return i
+		}
+		i += run
+	}
+	return -1
+}
+
+// Aliases are other names that wiki links can use for the entry.
+func (e Entry) Aliases() []string {
+	return e.metadata["alias"]
+}
+
+// names returns the normalized names that wiki links can use for the entry.
+func (e Entry) names() []string {
+	names := lo.Map(append([]string{e.Title()}, e.Aliases()...), func(name string, index int) string {
+		return normalizeName(name)
+	})
+	return lo.Uniq(lo.Compact(names))
+}
+
+// normalizeName makes names match regardless of case, spacing and heading markers.
+func normalizeName(name string) string {
+	name = strings.TrimLeft(strings.TrimSpace(name), "# ")
+	return strings.ToLower(strings.Join(strings.Fields(name), " "))
+}
+
+// ResolveWikiLink returns the ids of the entries titled or aliased target.
+// The link is ambiguous when there is more than one.
+func (s *Storage) ResolveWikiLink(target string) []string {
+	ids := slices.Clone(s.graph.names[normalizeName(target)])
+	slices.Sort(ids)
+	return ids
+}
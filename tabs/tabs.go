@@ -186,12 +186,16 @@ func (m Model) footerView() string {
 	w := lipgloss.Width
 
 	format := "Markdown"
+	style := statusStyle
 	if m.formatter == formatter.Default {
 		format = "Raw"
-		statusStyle = statusStyle.
-			Background(lipgloss.Color("#FF5F87"))
+		style = style.Background(lipgloss.Color("#FF5F87"))
 	}
-	statusKey := statusStyle.Render(format)
+	if _, ok := m.formatter.(*formatter.DiffFormatter); ok {
+		format = "Diff"
+		style = style.Background(lipgloss.Color("#43BF6D"))
+	}
+	statusKey := style.Render(format)
 	scroll := scrollStyle.Render(scrollPercent)
 	statusVal := statusTextStyle.
 		Width(m.width - w(statusKey) - w(scroll)).
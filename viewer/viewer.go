@@ -1,6 +1,7 @@
 package viewer
 
 import (
+	"fmt"
 	"github.com/TotallyNotLost/gotes/cmd"
 	"github.com/TotallyNotLost/gotes/formatter"
 	glist "github.com/TotallyNotLost/gotes/list"
@@ -14,6 +15,7 @@ import (
 	"github.com/charmbracelet/lipgloss"
 	"github.com/samber/lo"
 	"strconv"
+	"time"
 )
 
 var (
@@ -22,6 +24,8 @@ var (
 	minWidthForRelated      = 100
 	relatedViewWidthPercent = 0.4
 	relatedViewMaxWidth     = 40
+	diffHeaderStyle         = lipgloss.NewStyle().Bold(true)
+	confirmStyle            = lipgloss.NewStyle().Foreground(lipgloss.Color("214"))
 )
 
 type mode struct {
@@ -41,8 +45,14 @@ var normal = mode{
 	keyMap: keyMap{
 		Back:           key.NewBinding(key.WithKeys("esc"), key.WithHelp("esc", "back")),
 		Edit:           key.NewBinding(key.WithKeys("e"), key.WithHelp("e", "edit")),
+		EditExternally: key.NewBinding(key.WithKeys("E"), key.WithHelp("E", "edit in $EDITOR")),
 		ToggleMarkdown: key.NewBinding(key.WithKeys("m"), key.WithHelp("m", "toggle markdown")),
 		RelatedMode:    key.NewBinding(key.WithKeys("r"), key.WithHelp("r", "related")),
+		BacklinksMode:  key.NewBinding(key.WithKeys("b"), key.WithHelp("b", "backlinks")),
+		DiffMode:       key.NewBinding(key.WithKeys("d"), key.WithHelp("d", "diff")),
+		Restore:        key.NewBinding(key.WithKeys("R"), key.WithHelp("R", "restore revision")),
+		Archive:        key.NewBinding(key.WithKeys("a"), key.WithHelp("a", "archive/unarchive")),
+		Delete:         key.NewBinding(key.WithKeys("D"), key.WithHelp("D", "delete")),
 	},
 }
 
@@ -54,6 +64,24 @@ var related = mode{
 	},
 }
 
+var backlinks = mode{
+	id: 3,
+	keyMap: keyMap{
+		View:       key.NewBinding(key.WithKeys("enter"), key.WithHelp("enter", "view")),
+		NormalMode: key.NewBinding(key.WithKeys("esc"), key.WithHelp("esc", "normal")),
+	},
+}
+
+// In diff mode the active tab is compared with an older (or newer) base revision.
+var diffing = mode{
+	id: 2,
+	keyMap: keyMap{
+		NormalMode: key.NewBinding(key.WithKeys("esc", "d"), key.WithHelp("esc", "normal")),
+		OlderBase:  key.NewBinding(key.WithKeys("["), key.WithHelp("[", "older base")),
+		NewerBase:  key.NewBinding(key.WithKeys("]"), key.WithHelp("]", "newer base")),
+	},
+}
+
 func New(storage *storage.Storage) Model {
 	var d list.DefaultDelegate
 	d = list.NewDefaultDelegate()
@@ -62,16 +90,24 @@ func New(storage *storage.Storage) Model {
 	l.SetShowHelp(false)
 	l.Title = "Related"
 
+	bl := list.New([]list.Item{}, d, 0, 0)
+	bl.SetFilteringEnabled(false)
+	bl.SetShowHelp(false)
+	bl.Title = "Backlinks"
+
 	mdFormatter := formatter.NewMarkdownFormatter(storage)
+	diffFormatter := formatter.NewDiffFormatter()
 	tbs := tabs.New()
 	tbs.SetFormatter(mdFormatter)
 	return Model{
 		tabs:                tbs,
 		relatedList:         l,
 		relatedListDelegate: d,
+		backlinkList:        bl,
 		renderMarkdown:      true,
 		mode:                normal,
 		markdownFormatter:   mdFormatter,
+		diffFormatter:       diffFormatter,
 		storage:             storage,
 		help:                help.New(),
 	}
@@ -82,19 +118,29 @@ type Model struct {
 	revisions           []storage.Entry
 	relatedList         list.Model
 	relatedListDelegate list.DefaultDelegate
+	backlinkList        list.Model
 	lastActiveRevision  string
 	lastActiveTab       int
 	renderMarkdown      bool
 	mode                mode
 	markdownFormatter   *formatter.MarkdownFormatter
+	diffFormatter       *formatter.DiffFormatter
 	width               int
+	height              int
 	storage             *storage.Storage
 	help                help.Model
+	// Index of the revision the active tab is compared with in diff mode.
+	// len(revisions) compares with an empty note.
+	diffBase int
+	// Whether deleting the entry is waiting to be confirmed.
+	deleting bool
 }
 
 func (m *Model) SetHeight(height int) {
-	m.tabs.SetHeight(height - lipgloss.Height(m.tagsView()) - lipgloss.Height(m.helpView()))
-	m.relatedList.SetHeight(height)
+	m.height = height
+	m.tabs.SetHeight(height - lipgloss.Height(m.headerView()) - lipgloss.Height(m.helpView()))
+	m.relatedList.SetHeight(height - height/2)
+	m.backlinkList.SetHeight(height / 2)
 }
 
 func (m *Model) SetWidth(width int) {
@@ -107,24 +153,67 @@ func (m *Model) SetWidth(width int) {
 	m.tabs.SetWidth(width - paddingWidth)
 	m.markdownFormatter.SetWidth(width - paddingWidth)
 	m.relatedList.SetWidth(relatedViewWidth)
+	m.backlinkList.SetWidth(relatedViewWidth)
+}
+
+// ClearCache renders the revisions again the next time they're shown,
+// e.g. after the entries they include or link to changed.
+func (m *Model) ClearCache() {
+	m.markdownFormatter.ClearCache()
 }
 
 func (m *Model) SetRevisions(revisions []storage.Entry) {
 	m.revisions = revisions
+	now := time.Now()
 	tabs := lo.Map(m.revisions, func(revision storage.Entry, i int) tabs.Tab {
 		title := "Revision HEAD~" + strconv.Itoa(i)
 		if i == 0 {
 			title += " (latest)"
 		}
+		if updated, ok := revision.Updated(); ok {
+			title += " · " + relativeTime(updated, now)
+		}
+		if author := revision.Author(); author != "" {
+			title += " · " + author
+		}
+		if status := revision.Status(); status != "" {
+			title += " · " + status
+		}
+		if from := lo.LastOrEmpty(revision.Metadata()["reverted-from"]); from != "" {
+			title += " · reverted from revision " + from
+		}
 		return tabs.NewTab(title, revision.Text())
 	})
 	m.tabs.SetTabs(tabs)
 	m.tabs.SetEntryId(m.getActiveRevision().Id())
+	m.setMode(normal)
 	m.tabs.AdjustHeight()
-	m.mode = normal
 	m.updateRelatedList()
 }
 
+// EntryId returns the id of the entry being viewed.
+func (m Model) EntryId() string {
+	return m.getActiveRevision().Id()
+}
+
+// relativeTime describes t relative to now, e.g. "3h ago".
+func relativeTime(t time.Time, now time.Time) string {
+	d := now.Sub(t)
+	switch {
+	case d < 0:
+		return t.Local().Format("2006-01-02 15:04")
+	case d < time.Minute:
+		return "just now"
+	case d < time.Hour:
+		return fmt.Sprintf("%dm ago", int(d.Minutes()))
+	case d < 24*time.Hour:
+		return fmt.Sprintf("%dh ago", int(d.Hours()))
+	case d < 30*24*time.Hour:
+		return fmt.Sprintf("%dd ago", int(d.Hours()/24))
+	}
+	return t.Local().Format("2006-01-02")
+}
+
 func (m *Model) SetFocused(focused bool) {
 	m.tabs.SetFocused(focused)
 }
@@ -136,48 +225,144 @@ func (m Model) Init() tea.Cmd {
 func (m Model) Update(msg tea.Msg) (Model, tea.Cmd) {
 	switch msg := msg.(type) {
 	case tea.KeyMsg:
+		if m.deleting {
+			return m.updateDelete(msg)
+		}
 		switch {
 		case key.Matches(msg, m.mode.keyMap.Back):
 			return m, cmd.Back
 		case key.Matches(msg, m.mode.keyMap.Edit):
 			return m, cmd.EditEntry(m.getActiveRevision())
+		case key.Matches(msg, m.mode.keyMap.EditExternally):
+			return m, cmd.EditEntryExternally(m.getActiveRevision())
 		case key.Matches(msg, m.mode.keyMap.View):
-			entry := m.relatedList.SelectedItem().(*glist.Item).Entry()
-			return m, cmd.ViewEntry(entry)
+			l := m.relatedList
+			if m.mode.Equal(backlinks) {
+				l = m.backlinkList
+			}
+			if item, ok := l.SelectedItem().(*glist.Item); ok {
+				return m, cmd.ViewEntry(item.Entry())
+			}
+			return m, nil
 		case key.Matches(msg, m.mode.keyMap.ToggleMarkdown):
 			m.renderMarkdown = !m.renderMarkdown
-			if m.renderMarkdown {
-				m.tabs.SetFormatter(m.markdownFormatter)
-			} else {
-				m.tabs.SetFormatter(formatter.Default)
-			}
+			m.tabs.SetFormatter(m.formatter())
 		case key.Matches(msg, m.mode.keyMap.NormalMode):
-			m.mode = normal
+			m.setMode(normal)
 		case key.Matches(msg, m.mode.keyMap.RelatedMode):
-			m.mode = related
+			m.setMode(related)
+		case key.Matches(msg, m.mode.keyMap.BacklinksMode):
+			m.setMode(backlinks)
+		case key.Matches(msg, m.mode.keyMap.Restore):
+			if m.tabs.ActiveTab == 0 {
+				// Already the latest revision.
+				return m, nil
+			}
+			return m, cmd.RevertEntry(m.getActiveRevision(), len(m.revisions)-m.tabs.ActiveTab)
+		case key.Matches(msg, m.mode.keyMap.Archive) && len(m.revisions) > 0:
+			latest := m.revisions[0]
+			switch {
+			case latest.IsArchived():
+				return m, cmd.UnarchiveEntry(latest)
+			case !latest.IsDeleted():
+				return m, cmd.ArchiveEntry(latest)
+			}
+		case key.Matches(msg, m.mode.keyMap.Delete) && len(m.revisions) > 0:
+			if latest := m.revisions[0]; !latest.IsDeleted() {
+				m.deleting = true
+				m.SetHeight(m.height)
+				return m, nil
+			}
+		case key.Matches(msg, m.mode.keyMap.DiffMode):
+			m.diffBase = m.tabs.ActiveTab + 1
+			m.setMode(diffing)
+		case key.Matches(msg, m.mode.keyMap.OlderBase):
+			m.diffBase = min(m.diffBase+1, len(m.revisions))
+		case key.Matches(msg, m.mode.keyMap.NewerBase):
+			m.diffBase = max(m.diffBase-1, 0)
 		}
 	}
 
-	var cmd, rlCmd tea.Cmd
+	if m.mode.Equal(diffing) {
+		m.diffFormatter.SetBase(m.diffBaseText())
+	}
+
+	var cmd, rlCmd, blCmd tea.Cmd
 	m.tabs, cmd = m.tabs.Update(msg)
 	if m.mode.Equal(related) {
 		m.relatedList, rlCmd = m.relatedList.Update(msg)
 	}
+	if m.mode.Equal(backlinks) {
+		m.backlinkList, blCmd = m.backlinkList.Update(msg)
+	}
 
 	m.updateRelatedList()
 
-	return m, tea.Batch(cmd, rlCmd)
+	return m, tea.Batch(cmd, rlCmd, blCmd)
+}
+
+// updateDelete deletes the entry if the deletion is confirmed with y and cancels it otherwise.
+func (m Model) updateDelete(msg tea.KeyMsg) (Model, tea.Cmd) {
+	m.deleting = false
+	m.SetHeight(m.height)
+
+	if msg.String() == "y" || msg.String() == "Y" {
+		return m, cmd.DeleteEntry(m.revisions[0])
+	}
+	return m, nil
+}
+
+func (m *Model) setMode(mode mode) {
+	m.mode = mode
+	if mode.Equal(diffing) {
+		m.diffFormatter.SetBase(m.diffBaseText())
+	}
+	m.tabs.SetFormatter(m.formatter())
+	m.SetHeight(m.height)
+}
+
+func (m Model) formatter() formatter.Formatter {
+	switch {
+	case m.mode.Equal(diffing):
+		return m.diffFormatter
+	case m.renderMarkdown:
+		return m.markdownFormatter
+	}
+	return formatter.Default
+}
+
+func (m Model) diffBaseText() string {
+	if m.diffBase >= len(m.revisions) {
+		return ""
+	}
+	return m.revisions[m.diffBase].Text()
 }
 
 func (m Model) View() string {
 	body := m.tabs.View()
-	viewer := lipgloss.JoinVertical(lipgloss.Left, m.tagsView(), body, m.helpView())
+	viewer := lipgloss.JoinVertical(lipgloss.Left, m.headerView(), body, m.helpView())
 
 	if m.width < minWidthForRelated {
 		return viewerStyle.Render(viewer)
 	}
 
-	return lipgloss.JoinHorizontal(lipgloss.Top, viewerStyle.Render(viewer), m.relatedList.View())
+	side := lipgloss.JoinVertical(lipgloss.Left, m.relatedList.View(), m.backlinkList.View())
+	return lipgloss.JoinHorizontal(lipgloss.Top, viewerStyle.Render(viewer), side)
+}
+
+func (m Model) headerView() string {
+	if m.deleting {
+		return confirmStyle.Render(fmt.Sprintf("Delete %q? (y/n)", m.revisions[0].Title()))
+	}
+	if !m.mode.Equal(diffing) {
+		return m.tagsView()
+	}
+
+	base := "empty note"
+	if m.diffBase < len(m.revisions) {
+		base = "HEAD~" + strconv.Itoa(m.diffBase)
+	}
+	return diffHeaderStyle.Render(fmt.Sprintf("Changes from %s to HEAD~%d", base, m.tabs.ActiveTab))
 }
 
 func (m Model) tagsView() string {
@@ -186,16 +371,8 @@ func (m Model) tagsView() string {
 }
 
 func (m *Model) updateRelatedList() {
-	var d list.DefaultDelegate
-	d = m.relatedListDelegate
-
-	if m.mode.Equal(normal) {
-		// Change colors
-		d.Styles.SelectedTitle = d.Styles.NormalTitle
-		d.Styles.SelectedDesc = d.Styles.NormalDesc
-	}
-
-	m.relatedList.SetDelegate(d)
+	m.relatedList.SetDelegate(m.listDelegate(related))
+	m.backlinkList.SetDelegate(m.listDelegate(backlinks))
 
 	if m.lastActiveRevision == m.getActiveRevision().Id() && m.lastActiveTab == m.tabs.ActiveTab {
 		// Already up to date
@@ -210,16 +387,41 @@ func (m *Model) updateRelatedList() {
 	m.relatedList.SetItems(lo.Map(entries, func(entry storage.Entry, index int) list.Item {
 		return glist.EntryToItem(m.storage, entry)
 	}))
+
+	m.backlinkList.SetItems(lo.Map(m.storage.Backlinks(m.getActiveRevision().Id()), func(b storage.Backlink, index int) list.Item {
+		item := glist.EntryToItem(m.storage, b.Entry)
+		item.SetSnippet(b.Kind.String() + ": " + b.Context)
+		return item
+	}))
+}
+
+// listDelegate only highlights the selected item of the list that mode navigates.
+func (m Model) listDelegate(mode mode) list.DefaultDelegate {
+	d := m.relatedListDelegate
+	if !m.mode.Equal(mode) {
+		// Change colors
+		d.Styles.SelectedTitle = d.Styles.NormalTitle
+		d.Styles.SelectedDesc = d.Styles.NormalDesc
+	}
+	return d
 }
 
 func (m Model) ShortHelp() []key.Binding {
 	return []key.Binding{
 		m.mode.keyMap.Back,
 		m.mode.keyMap.Edit,
+		m.mode.keyMap.EditExternally,
 		m.mode.keyMap.View,
 		m.mode.keyMap.ToggleMarkdown,
 		m.mode.keyMap.NormalMode,
 		m.mode.keyMap.RelatedMode,
+		m.mode.keyMap.BacklinksMode,
+		m.mode.keyMap.DiffMode,
+		m.mode.keyMap.Restore,
+		m.mode.keyMap.Archive,
+		m.mode.keyMap.Delete,
+		m.mode.keyMap.OlderBase,
+		m.mode.keyMap.NewerBase,
 	}
 }
 
@@ -241,8 +443,16 @@ func (m Model) helpView() string {
 type keyMap struct {
 	Back           key.Binding
 	Edit           key.Binding
+	EditExternally key.Binding
 	View           key.Binding
 	ToggleMarkdown key.Binding
 	NormalMode     key.Binding
 	RelatedMode    key.Binding
+	BacklinksMode  key.Binding
+	DiffMode       key.Binding
+	Restore        key.Binding
+	Archive        key.Binding
+	Delete         key.Binding
+	OlderBase      key.Binding
+	NewerBase      key.Binding
 }
@@ -0,0 +1,176 @@
+package watch
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Changes to the same file within this window are reported once.
+const debounce = 100 * time.Millisecond
+
+// PollInterval is how often files are checked when native notifications aren't available.
+var PollInterval = time.Second
+
+// Watcher reports when any of a set of files changes.
+type Watcher struct {
+	// Absolute paths of the files, and the names they were given as.
+	files   []string
+	names   map[string]string
+	changes chan []string
+	raw     chan string
+	done    chan struct{}
+	close   func() error
+	once    sync.Once
+}
+
+// New watches files for changes, using inotify on Linux
+// and falling back to polling elsewhere or if inotify isn't available.
+func New(files []string) *Watcher {
+	w := newWatcher(files)
+
+	if err := w.startNative(); err != nil {
+		w.startPolling(PollInterval)
+	}
+
+	go w.coalesce()
+
+	return w
+}
+
+// NewPolling watches files by checking their size and modification time every interval.
+func NewPolling(files []string, interval time.Duration) *Watcher {
+	w := newWatcher(files)
+	w.startPolling(interval)
+
+	go w.coalesce()
+
+	return w
+}
+
+func newWatcher(files []string) *Watcher {
+	abs := make([]string, 0, len(files))
+	names := make(map[string]string)
+	for _, f := range files {
+		a, err := filepath.Abs(f)
+		if err != nil {
+			a = f
+		}
+		abs = append(abs, a)
+		names[a] = f
+	}
+
+	return &Watcher{
+		files:   abs,
+		names:   names,
+		changes: make(chan []string),
+		raw:     make(chan string, 64),
+		done:    make(chan struct{}),
+	}
+}
+
+// Changes receives the files, as given to New, that changed.
+func (w *Watcher) Changes() <-chan []string {
+	return w.changes
+}
+
+func (w *Watcher) Close() error {
+	var err error
+	w.once.Do(func() {
+		close(w.done)
+		if w.close != nil {
+			err = w.close()
+		}
+	})
+	return err
+}
+
+// notify reports a change to path, which must be absolute.
+func (w *Watcher) notify(path string) {
+	if !slices.Contains(w.files, path) {
+		return
+	}
+	select {
+	case w.raw <- path:
+	case <-w.done:
+	}
+}
+
+// coalesce batches raw changes so that a burst of writes is reported once.
+func (w *Watcher) coalesce() {
+	var (
+		pending []string
+		timer   <-chan time.Time
+	)
+
+	for {
+		select {
+		case <-w.done:
+			return
+		case path := <-w.raw:
+			if !slices.Contains(pending, path) {
+				pending = append(pending, path)
+			}
+			if timer == nil {
+				timer = time.After(debounce)
+			}
+		case <-timer:
+			select {
+			case w.changes <- w.original(pending):
+			case <-w.done:
+				return
+			}
+			pending, timer = nil, nil
+		}
+	}
+}
+
+// original maps absolute paths back to how they were given to New.
+func (w *Watcher) original(paths []string) []string {
+	names := make([]string, len(paths))
+	for i, p := range paths {
+		names[i] = w.names[p]
+	}
+	return names
+}
+
+func (w *Watcher) startPolling(interval time.Duration) {
+	type stamp struct {
+		size    int64
+		modTime time.Time
+	}
+
+	stat := func(file string) stamp {
+		info, err := os.Stat(file)
+		if err != nil {
+			return stamp{size: -1}
+		}
+		return stamp{size: info.Size(), modTime: info.ModTime()}
+	}
+
+	last := make(map[string]stamp)
+	for _, f := range w.files {
+		last[f] = stat(f)
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-w.done:
+				return
+			case <-ticker.C:
+				for _, f := range w.files {
+					if s := stat(f); s != last[f] {
+						last[f] = s
+						w.notify(f)
+					}
+				}
+			}
+		}
+	}()
+}
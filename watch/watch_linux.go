@@ -0,0 +1,67 @@
+package watch
+
+import (
+	"github.com/samber/lo"
+	"os"
+	"path/filepath"
+	"syscall"
+	"unsafe"
+)
+
+// Editors often save by writing a new file and renaming it over the old one,
+// so the directories containing the files are watched instead of the files.
+const inotifyMask = syscall.IN_CLOSE_WRITE | syscall.IN_MODIFY | syscall.IN_MOVED_TO |
+	syscall.IN_CREATE | syscall.IN_DELETE | syscall.IN_MOVED_FROM
+
+func (w *Watcher) startNative() error {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return err
+	}
+
+	dirs := make(map[int32]string)
+	for _, dir := range lo.Uniq(lo.Map(w.files, func(f string, index int) string { return filepath.Dir(f) })) {
+		wd, err := syscall.InotifyAddWatch(fd, dir, inotifyMask)
+		if err != nil {
+			syscall.Close(fd)
+			return err
+		}
+		dirs[int32(wd)] = dir
+	}
+
+	// A non-blocking file goes through the runtime poller so Close unblocks Read.
+	f := os.NewFile(uintptr(fd), "inotify")
+	w.close = f.Close
+
+	go func() {
+		buf := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+
+		for {
+			n, err := f.Read(buf)
+			if err != nil {
+				return
+			}
+
+			for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+				event := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+				name := buf[offset+syscall.SizeofInotifyEvent : offset+syscall.SizeofInotifyEvent+int(event.Len)]
+				offset += syscall.SizeofInotifyEvent + int(event.Len)
+
+				if dir, ok := dirs[event.Wd]; ok {
+					w.notify(filepath.Join(dir, string(trimNul(name))))
+				}
+			}
+		}
+	}()
+
+	return nil
+}
+
+func trimNul(b []byte) []byte {
+	for i, c := range b {
+		if c == 0 {
+			return b[:i]
+		}
+	}
+	return b
+}
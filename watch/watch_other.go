@@ -0,0 +1,11 @@
+//go:build !linux
+
+package watch
+
+import (
+	"errors"
+)
+
+func (w *Watcher) startNative() error {
+	return errors.New("native file notifications aren't supported on this platform")
+}